@@ -0,0 +1,202 @@
+package mongo_log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// adminAPI serves endpoints to inspect the mongo_log writers.
+type adminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.mongo_log",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+// Routes returns the admin routes for mongo_log.
+func (a *adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/mongo_log/query",
+			Handler: caddy.AdminHandlerFunc(a.handleQuery),
+		},
+		{
+			Pattern: "/mongo_log/status",
+			Handler: caddy.AdminHandlerFunc(a.handleStatus),
+		},
+	}
+}
+
+// handleStatus reports the delivery state of every open writer.
+func (a *adminAPI) handleStatus(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	statuses := writerStatuses()
+	if statuses == nil {
+		statuses = []writerStatus{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(statuses)
+}
+
+// handleQuery returns the most recent documents of a writer matching the
+// since, until, status, host, path and request_id parameters. The writer is
+// chosen with the writer parameter when more than one is open.
+func (a *adminAPI) handleQuery(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	docs, err := queryLogs(r.Context(), r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(docs)
+}
+
+// queryLogs runs a query given as handleQuery parameters. Errors are
+// caddy.APIErrors carrying the status to respond with.
+func queryLogs(ctx context.Context, query url.Values) ([]bson.M, error) {
+	writer, ok := lookupWriter(query.Get("writer"))
+	if !ok {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("unknown writer %q", query.Get("writer")),
+		}
+	}
+
+	filter, err := writer.config.queryFilter(query.Get("since"), query.Get("until"), query.Get("status"), query.Get("host"), query.Get("path"), query.Get("request_id"))
+	if err != nil {
+		return nil, caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+
+	limit := int64(defaultQueryLimit)
+	if s := query.Get("limit"); s != "" {
+		if limit, err = strconv.ParseInt(s, 10, 64); err != nil || limit <= 0 {
+			return nil, caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("invalid limit %q", s)}
+		}
+	}
+	limit = min(limit, maxQueryLimit)
+
+	t := writer.config.target(nil, time.Now())
+	if s := query.Get("collection"); s != "" {
+		t.collection = s
+	}
+
+	collection, err := writer.readCollection(t)
+	if err != nil {
+		return nil, caddy.APIError{HTTPStatus: http.StatusServiceUnavailable, Err: err}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: writer.config.dateField(), Value: -1}}).
+		SetLimit(limit))
+	if err != nil {
+		return nil, caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+	}
+
+	docs := []bson.M{}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+	}
+
+	return docs, nil
+}
+
+// queryFilter builds a filter over the default document layout. Times are
+// RFC 3339 or a duration before now, e.g. "15m".
+func (l *MongoLog) queryFilter(since, until, status, host, path, requestID string) (bson.M, error) {
+	filter := bson.M{}
+
+	date := bson.M{}
+	for op, s := range map[string]string{"$gte": since, "$lte": until} {
+		if s == "" {
+			continue
+		}
+
+		t, err := parseQueryTime(s)
+		if err != nil {
+			return nil, err
+		}
+		date[op] = primitive.NewDateTimeFromTime(t)
+	}
+	if len(date) > 0 {
+		filter[l.dateField()] = date
+	}
+
+	if status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q", status)
+		}
+		filter[l.metadataField("status")] = code
+	}
+
+	if host != "" {
+		filter[l.metadataField("request.host")] = host
+	}
+
+	if path != "" {
+		filter[l.metadataField("request.uri")] = bson.M{"$regex": "^" + regexp.QuoteMeta(path)}
+	}
+
+	if requestID != "" {
+		filter["$or"] = bson.A{
+			bson.M{requestIdField: requestID},
+			bson.M{l.metadataField("resp_headers.X-Request-Id"): requestID},
+			bson.M{l.metadataField("request.headers.X-Request-Id"): requestID},
+		}
+	}
+
+	return filter, nil
+}
+
+func parseQueryTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	d, err := caddy.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// Interface guards.
+var (
+	_ caddy.AdminRouter = (*adminAPI)(nil)
+)
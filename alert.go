@@ -0,0 +1,197 @@
+package mongo_log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAlertWindow  = 5 * time.Minute
+	alertCheckInterval  = 15 * time.Second
+	alertWebhookTimeout = 10 * time.Second
+	alertStatusFiring   = "firing"
+	alertStatusResolved = "resolved"
+)
+
+// Alert posts to Webhook when the share of failed insert attempts reaches
+// FailureRate, or the queue holds QueueDepth entries or more, for a whole
+// Window, and again once it recovers. The payload has a Slack style text
+// field next to the figures.
+type Alert struct {
+	Webhook string `json:"webhook,omitempty"`
+	// Share of failed insert attempts, from 0 to 1.
+	FailureRate float64 `json:"failure_rate,omitempty"`
+	QueueDepth  int     `json:"queue_depth,omitempty"`
+	// How long a threshold must stay exceeded, 5m by default.
+	Window caddy.Duration `json:"window,omitempty"`
+}
+
+func parseAlert(d *caddyfile.Dispenser) (*Alert, error) {
+	a := &Alert{}
+
+	if d.NextArg() {
+		a.Webhook = d.Val()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		option := d.Val()
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+
+		switch option {
+		case "webhook":
+			a.Webhook = d.Val()
+
+		case "failure_rate":
+			rate, err := strconv.ParseFloat(d.Val(), 64)
+			if err != nil {
+				return nil, d.Errf("invalid alert failure_rate %q: %v", d.Val(), err)
+			}
+			a.FailureRate = rate
+
+		case "queue_depth":
+			depth, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid alert queue_depth %q: %v", d.Val(), err)
+			}
+			a.QueueDepth = depth
+
+		case "window":
+			window, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid alert window %q: %v", d.Val(), err)
+			}
+			a.Window = caddy.Duration(window)
+
+		default:
+			return nil, d.Errf("unrecognized alert option %q", option)
+		}
+	}
+
+	return a, nil
+}
+
+func (a *Alert) provision() {
+	if a.Window <= 0 {
+		a.Window = caddy.Duration(defaultAlertWindow)
+	}
+}
+
+func (a *Alert) validate() error {
+	u, err := url.Parse(a.Webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("alert webhook must be an http or https URL, got %q", a.Webhook)
+	}
+
+	if a.FailureRate <= 0 && a.QueueDepth <= 0 {
+		return fmt.Errorf("alert needs a failure_rate or queue_depth threshold")
+	}
+
+	if a.FailureRate > 1 {
+		return fmt.Errorf("alert failure_rate must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// alertLoop checks the thresholds every alertCheckInterval until done is
+// closed.
+func (mWrite *mongoWriter) alertLoop() {
+	defer mWrite.wg.Done()
+
+	a := mWrite.config.Alert
+	ticker := time.NewTicker(alertCheckInterval)
+	defer ticker.Stop()
+
+	var exceededSince time.Time
+	var firing, failing bool
+	lastAttempts, lastFailed := mWrite.insertAttempts.Load(), mWrite.failedAttempts.Load()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-mWrite.done:
+			return
+		}
+
+		attempts, failed := mWrite.insertAttempts.Load(), mWrite.failedAttempts.Load()
+		rate := 0.0
+		// without attempts, e.g. while the breaker is open, failing stays as it was
+		if n := attempts - lastAttempts; n > 0 {
+			rate = float64(failed-lastFailed) / float64(n)
+			failing = a.FailureRate > 0 && rate >= a.FailureRate
+		}
+		lastAttempts, lastFailed = attempts, failed
+
+		depth := len(mWrite.queue)
+		exceeded := failing || (a.QueueDepth > 0 && depth >= a.QueueDepth)
+
+		switch {
+		case exceeded && exceededSince.IsZero():
+			exceededSince = time.Now()
+
+		case exceeded && !firing && time.Since(exceededSince) >= time.Duration(a.Window):
+			firing = true
+			mWrite.postAlert(alertStatusFiring, rate, depth, exceededSince)
+
+		case !exceeded && !exceededSince.IsZero():
+			if firing {
+				mWrite.postAlert(alertStatusResolved, rate, depth, exceededSince)
+			}
+			exceededSince, firing = time.Time{}, false
+		}
+	}
+}
+
+func (mWrite *mongoWriter) postAlert(status string, rate float64, depth int, since time.Time) {
+	text := fmt.Sprintf("mongo_log writer %s: insert failure rate %.0f%%, queue depth %d, since %s",
+		mWrite.metricsLabel, rate*100, depth, since.UTC().Format(time.RFC3339))
+	if status == alertStatusResolved {
+		text = fmt.Sprintf("mongo_log writer %s recovered after %s", mWrite.metricsLabel, time.Since(since).Round(time.Second))
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"text":         text,
+		"status":       status,
+		"writer":       mWrite.metricsLabel,
+		"failure_rate": rate,
+		"queue_depth":  depth,
+		"since":        since.UTC(),
+		"dropped":      mWrite.dropped.Load(),
+	})
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), alertWebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, mWrite.config.Alert.Webhook, bytes.NewReader(body))
+	if err != nil {
+		mWrite.logger.Error("Could not build alert webhook request", zap.Error(err))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		mWrite.logger.Error("Could not post alert webhook", zap.String("status", status), zap.Error(err))
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		mWrite.logger.Error("Alert webhook rejected the alert", zap.String("status", status), zap.Int("code", resp.StatusCode))
+	}
+}
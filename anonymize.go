@@ -0,0 +1,71 @@
+package mongo_log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+)
+
+const (
+	anonymizeTruncate = "truncate"
+	anonymizeHash     = "hash"
+)
+
+// ipFields are the entry fields holding client addresses.
+var ipFields = [][]string{
+	{"request", "remote_ip"},
+	{"request", "client_ip"},
+}
+
+func validateAnonymizeIP(mode, salt string) error {
+	switch mode {
+	case "", anonymizeTruncate:
+		return nil
+	case anonymizeHash:
+		// unsalted, every IPv4 hash is reversed by hashing all 2^32
+		// addresses
+		if salt == "" {
+			return fmt.Errorf("anonymize_ip %s requires anonymize_ip_salt", anonymizeHash)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid anonymize_ip mode %q", mode)
+	}
+}
+
+// anonymizeIP truncates an address to its /24 (IPv4) or /64 (IPv6)
+// network, or replaces it with a salted hash.
+func anonymizeIP(ip string, mode string, salt string) string {
+	if mode == anonymizeHash {
+		sum := sha256.Sum256([]byte(salt + ip))
+		return hex.EncodeToString(sum[:])
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+
+	bits := 64
+	if addr.Unmap().Is4() {
+		addr = addr.Unmap()
+		bits = 24
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.Addr().String()
+}
+
+func (l *MongoLog) anonymizeIPs(entry map[string]interface{}) {
+	for _, path := range ipFields {
+		if v, ok := lookup(entry, path...); ok {
+			if ip, ok := v.(string); ok && ip != "" {
+				setPath(entry, path, anonymizeIP(ip, l.AnonymizeIP, l.AnonymizeIPSalt))
+			}
+		}
+	}
+}
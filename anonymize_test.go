@@ -0,0 +1,53 @@
+package mongo_log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	sum := sha256.Sum256([]byte("pepper" + "203.0.113.7"))
+	hashed := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		ip   string
+		mode string
+		salt string
+		want string
+	}{
+		{ip: "203.0.113.7", want: "203.0.113.0"},
+		{ip: "203.0.113.7", mode: anonymizeTruncate, want: "203.0.113.0"},
+		{ip: "::ffff:203.0.113.7", want: "203.0.113.0"},
+		{ip: "2001:db8:1:2:3:4:5:6", want: "2001:db8:1:2::"},
+		{ip: "fe80::1%eth0", want: "fe80::"},
+		{ip: "not an address", want: ""},
+		{ip: "203.0.113.7", mode: anonymizeHash, salt: "pepper", want: hashed},
+	}
+
+	for _, tt := range tests {
+		if got := anonymizeIP(tt.ip, tt.mode, tt.salt); got != tt.want {
+			t.Errorf("anonymizeIP(%q, %q) = %q, want %q", tt.ip, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestValidateAnonymizeIP(t *testing.T) {
+	tests := []struct {
+		mode    string
+		salt    string
+		wantErr bool
+	}{
+		{mode: ""},
+		{mode: anonymizeTruncate},
+		{mode: anonymizeHash, salt: "pepper"},
+		{mode: anonymizeHash, wantErr: true},
+		{mode: "mask", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if err := validateAnonymizeIP(tt.mode, tt.salt); (err != nil) != tt.wantErr {
+			t.Errorf("validateAnonymizeIP(%q, %q) = %v, want error %v", tt.mode, tt.salt, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package mongo_log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultArchiveSchedule  = "@daily"
+	defaultArchiveBatchSize = 10000
+	archiveTimeout          = 5 * time.Minute
+)
+
+// Archive exports documents older than After to gzipped JSON lines files
+// and deletes them once stored. Destination is a local directory, or an
+// s3://bucket/prefix or gs://bucket/prefix URL; GCS is written through its
+// S3 interoperability API with HMAC keys.
+type Archive struct {
+	After       caddy.Duration `json:"after,omitempty"`
+	Schedule    string         `json:"schedule,omitempty"`
+	Destination string         `json:"destination,omitempty"`
+	BatchSize   int            `json:"batch_size,omitempty"`
+
+	// Object storage settings. Without an access key, credentials are
+	// taken from the AWS environment, credentials file or instance role.
+	Endpoint  string `json:"endpoint,omitempty"`
+	Region    string `json:"region,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+
+	schedule cron.Schedule
+	store    archiveStore
+}
+
+// archiveStore saves one archive file.
+type archiveStore interface {
+	put(ctx context.Context, name string, data []byte) error
+}
+
+func parseArchive(d *caddyfile.Dispenser) (*Archive, error) {
+	a := &Archive{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		option := d.Val()
+
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+
+		switch option {
+		case "after":
+			after, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid archive after %q: %v", d.Val(), err)
+			}
+			a.After = caddy.Duration(after)
+
+		case "schedule":
+			a.Schedule = d.Val()
+
+		case "destination":
+			a.Destination = d.Val()
+
+		case "batch_size":
+			size, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid archive batch_size %q: %v", d.Val(), err)
+			}
+			a.BatchSize = size
+
+		case "endpoint":
+			a.Endpoint = d.Val()
+
+		case "region":
+			a.Region = d.Val()
+
+		case "access_key":
+			a.AccessKey = d.Val()
+
+		case "secret_key":
+			a.SecretKey = d.Val()
+
+		default:
+			return nil, d.Errf("unrecognized archive option %q", option)
+		}
+	}
+
+	return a, nil
+}
+
+func (a *Archive) provision() error {
+	if a.Schedule == "" {
+		a.Schedule = defaultArchiveSchedule
+	}
+
+	if a.BatchSize <= 0 {
+		a.BatchSize = defaultArchiveBatchSize
+	}
+
+	schedule, err := cron.ParseStandard(a.Schedule)
+	if err != nil {
+		return fmt.Errorf("invalid archive schedule %q: %v", a.Schedule, err)
+	}
+	a.schedule = schedule
+
+	repl := caddy.NewReplacer()
+	a.AccessKey = repl.ReplaceKnown(a.AccessKey, "")
+	a.SecretKey = repl.ReplaceKnown(a.SecretKey, "")
+
+	if a.Destination == "" {
+		return nil
+	}
+
+	a.store, err = a.openStore()
+	return err
+}
+
+func (a *Archive) validate() error {
+	if a.After <= 0 {
+		return fmt.Errorf("archive requires an after age")
+	}
+	if a.Destination == "" {
+		return fmt.Errorf("archive requires a destination")
+	}
+	return nil
+}
+
+func (a *Archive) openStore() (archiveStore, error) {
+	u, err := url.Parse(a.Destination)
+	if err != nil || u.Scheme == "" || u.Scheme == "file" {
+		dir := a.Destination
+		if err == nil && u.Scheme == "file" {
+			dir = u.Path
+		}
+		return dirStore{dir: dir}, nil
+	}
+
+	endpoint := a.Endpoint
+	switch u.Scheme {
+	case "s3":
+		if endpoint == "" {
+			endpoint = "s3.amazonaws.com"
+		}
+	case "gs":
+		if endpoint == "" {
+			endpoint = "storage.googleapis.com"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported archive destination %q", a.Destination)
+	}
+
+	secure := true
+	if e, ok := strings.CutPrefix(endpoint, "http://"); ok {
+		endpoint, secure = e, false
+	}
+	endpoint = strings.TrimPrefix(endpoint, "https://")
+
+	creds := credentials.NewStaticV4(a.AccessKey, a.SecretKey, "")
+	if a.AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: secure,
+		Region: a.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("archive destination %q: %v", a.Destination, err)
+	}
+
+	return s3Store{client: client, bucket: u.Host, prefix: strings.Trim(u.Path, "/")}, nil
+}
+
+type dirStore struct {
+	dir string
+}
+
+func (s dirStore) put(ctx context.Context, name string, data []byte) error {
+	file := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func (s s3Store) put(ctx context.Context, name string, data []byte) error {
+	_, err := s.client.PutObject(ctx, s.bucket, path.Join(s.prefix, name), bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/x-ndjson", ContentEncoding: "gzip"})
+	return err
+}
+
+// archiveLoop runs the archive on its schedule until done is closed.
+func (mWrite *mongoWriter) archiveLoop() {
+	defer mWrite.wg.Done()
+
+	a := mWrite.config.Archive
+	for {
+		timer := time.NewTimer(time.Until(a.schedule.Next(time.Now())))
+
+		select {
+		case <-timer.C:
+			mWrite.archive()
+		case <-mWrite.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// archive moves old documents of the default collection and every
+// collection this writer has written to into the archive.
+func (mWrite *mongoWriter) archive() {
+	cutoff := primitive.NewDateTimeFromTime(time.Now().Add(-time.Duration(mWrite.config.Archive.After)))
+
+	for _, t := range mWrite.knownTargets() {
+		archived, err := mWrite.archiveCollection(t, cutoff)
+		if err != nil {
+			mWrite.logger.Error("Could not archive log collection", zap.String("collection", t.collection), zap.Error(err))
+		}
+		if archived > 0 {
+			mWrite.logger.Info("Archived log documents", zap.String("collection", t.collection), zap.Int64("count", archived))
+		}
+	}
+}
+
+// archiveCollection exports documents dated before cutoff, oldest first,
+// one file per batch, deleting each batch only after its file is stored.
+func (mWrite *mongoWriter) archiveCollection(t target, cutoff primitive.DateTime) (int64, error) {
+	a := mWrite.config.Archive
+
+	collection, err := mWrite.collectionFor(t)
+	if err != nil {
+		return 0, err
+	}
+
+	dateField := mWrite.config.dateField()
+	filter := bson.M{dateField: bson.M{"$lt": cutoff}}
+	find := options.Find().
+		SetSort(bson.D{{Key: dateField, Value: 1}}).
+		SetLimit(int64(a.BatchSize))
+
+	var total int64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), archiveTimeout)
+		n, err := mWrite.archiveBatch(ctx, t, collection, filter, find)
+		cancel()
+
+		total += int64(n)
+		if err != nil || n < a.BatchSize {
+			return total, err
+		}
+
+		select {
+		case <-mWrite.done:
+			return total, nil
+		default:
+		}
+	}
+}
+
+// archiveBatch stores one batch of documents as a file and deletes them,
+// returning how many were archived.
+func (mWrite *mongoWriter) archiveBatch(ctx context.Context, t target, collection *mongo.Collection, filter bson.M, find *options.FindOptions) (int, error) {
+	cursor, err := collection.Find(ctx, filter, find)
+	if err != nil {
+		return 0, err
+	}
+
+	var docs []bson.Raw
+	if err := cursor.All(ctx, &docs); err != nil {
+		return 0, err
+	}
+
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	ids := make(bson.A, 0, len(docs))
+
+	for _, doc := range docs {
+		line, err := bson.MarshalExtJSON(doc, false, false)
+		if err != nil {
+			return 0, err
+		}
+		zw.Write(line)
+		zw.Write([]byte{'\n'})
+
+		ids = append(ids, doc.Lookup("_id"))
+	}
+
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+
+	name := fmt.Sprintf("%s/%s/%s.jsonl.gz", t.database, t.collection,
+		time.Now().UTC().Format("20060102T150405.000000000Z"))
+	if err := mWrite.config.Archive.store.put(ctx, name, buf.Bytes()); err != nil {
+		return 0, fmt.Errorf("storing %s: %v", name, err)
+	}
+
+	if _, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return 0, err
+	}
+
+	return len(docs), nil
+}
@@ -0,0 +1,348 @@
+package mongo_log
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(MongoLogAudit{})
+	httpcaddyfile.RegisterGlobalOption("mongo_log_audit", parseAuditOption)
+}
+
+const (
+	defaultAuditCollection = "caddy_config_audit"
+	maxAuditChanges        = 1000
+	auditReadTimeout       = time.Minute
+)
+
+// sensitiveConfigKeys are config keys whose values are never stored in the
+// audit trail.
+var sensitiveConfigKeys = regexp.MustCompile(`(?i)pass|secret|token|credential|private|api_?key`)
+
+// lastAudited is the config recorded by the previous load. Each load
+// provisions a new MongoLogAudit, so it outlives them.
+var lastAudited struct {
+	sync.Mutex
+	config interface{}
+	hash   string
+}
+
+// MongoLogAudit is an app that records each config change in a mongo
+// collection through a mongo_log writer: when it happened, the config
+// hash before and after, and the changed paths as JSON patch style
+// add, remove and replace operations. Secrets and URI credentials are
+// redacted. Who made a change is in the admin.api request log entries,
+// which a mongo_log writer can store too.
+type MongoLogAudit struct {
+	// The writer to store changes with, named by database.collection.
+	// May be empty when only one writer is open.
+	Writer string `json:"writer,omitempty"`
+	// Collection in the writer's database, caddy_config_audit by default.
+	Collection string `json:"collection,omitempty"`
+	// Admin API address to read the loaded config from, Caddy's default
+	// admin address if empty.
+	Admin string `json:"admin,omitempty"`
+
+	logger *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (MongoLogAudit) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "mongo_log_audit",
+		New: func() caddy.Module { return new(MongoLogAudit) },
+	}
+}
+
+func (a *MongoLogAudit) Provision(ctx caddy.Context) error {
+	a.logger = ctx.Logger()
+
+	if a.Collection == "" {
+		a.Collection = defaultAuditCollection
+	}
+	if a.Admin == "" {
+		a.Admin = caddy.DefaultAdminListen
+	}
+
+	return nil
+}
+
+// Start records the config being loaded. The admin API serves it once
+// the load completes, so it is read in the background.
+func (a *MongoLogAudit) Start() error {
+	go a.record(time.Now())
+	return nil
+}
+
+func (*MongoLogAudit) Stop() error { return nil }
+
+func (a *MongoLogAudit) record(loaded time.Time) {
+	config, err := a.readConfig()
+	if err != nil {
+		a.logger.Error("Could not read the loaded config to audit", zap.String("admin", a.Admin), zap.Error(err))
+		return
+	}
+	redactConfig(config)
+
+	raw, _ := json.Marshal(config)
+	sum := sha256.Sum256(raw)
+	hash := hex.EncodeToString(sum[:])
+
+	lastAudited.Lock()
+	previous, previousHash := lastAudited.config, lastAudited.hash
+	lastAudited.config, lastAudited.hash = config, hash
+	lastAudited.Unlock()
+
+	// e.g. a reload that failed and was rolled back
+	if hash == previousHash {
+		return
+	}
+
+	changes := diffConfig(previous, config)
+
+	doc := bson.M{
+		"event":   "config_load",
+		"hash":    hash,
+		"changes": changes[:min(len(changes), maxAuditChanges)],
+	}
+	if previousHash != "" {
+		doc["previous_hash"] = previousHash
+	}
+	if len(changes) > maxAuditChanges {
+		doc["changes_truncated"] = len(changes) - maxAuditChanges
+	}
+	if id, err := caddy.InstanceID(); err == nil {
+		doc["instance_id"] = id.String()
+	}
+
+	w, ok := lookupWriter(a.Writer)
+	if !ok {
+		a.logger.Warn("No mongo_log writer to store config change in", zap.String("writer", a.Writer), zap.Strings("open", writerNames()))
+		return
+	}
+
+	doc[w.config.dateField()] = primitive.NewDateTimeFromTime(loaded)
+	w.addDocument(target{database: w.config.Database, collection: a.Collection, auxiliary: true}, doc)
+}
+
+// readConfig gets the running config from the admin API, over TCP or a
+// unix socket.
+func (a *MongoLogAudit) readConfig() (interface{}, error) {
+	addr, err := caddy.ParseNetworkAddress(a.Admin)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin address %q: %v", a.Admin, err)
+	}
+
+	origin := "http://" + addr.JoinHostPort(0)
+	dialAddr := addr.JoinHostPort(0)
+	if addr.IsUnixNetwork() {
+		// the admin API wants a loopback Host on unix sockets; drop any
+		// socket permission bits from the path
+		origin = "http://127.0.0.1"
+		dialAddr, _, _ = strings.Cut(addr.Host, "|")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), auditReadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/config/", nil)
+	if err != nil {
+		return nil, err
+	}
+	if !addr.IsUnixNetwork() {
+		req.Header.Set("Origin", origin)
+	}
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, addr.Network, dialAddr)
+		},
+	}}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin API responded %s", resp.Status)
+	}
+
+	var config interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// redactConfig replaces secrets in a decoded config, and the passwords of
+// any URI, in place.
+func redactConfig(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if sensitiveConfigKeys.MatchString(key) {
+				if value != nil && value != "" {
+					v[key] = redacted
+				}
+				continue
+			}
+
+			if s, ok := value.(string); ok {
+				v[key] = redactURI(s)
+				continue
+			}
+			redactConfig(value)
+		}
+
+	case []interface{}:
+		for i, value := range v {
+			if s, ok := value.(string); ok {
+				v[i] = redactURI(s)
+				continue
+			}
+			redactConfig(value)
+		}
+	}
+}
+
+func redactURI(s string) string {
+	if !strings.Contains(s, "://") || !strings.Contains(s, "@") {
+		return s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// diffConfig lists the operations turning before into after, keyed by
+// admin API style paths such as /apps/http/servers/srv0/listen/0.
+func diffConfig(before, after interface{}) []bson.M {
+	old, updated := map[string]interface{}{}, map[string]interface{}{}
+	flattenConfig(before, "", old)
+	flattenConfig(after, "", updated)
+
+	var changes []bson.M
+	for path, value := range updated {
+		previous, existed := old[path]
+		switch {
+		case !existed:
+			changes = append(changes, bson.M{"op": "add", "path": path, "value": value})
+		case !reflect.DeepEqual(previous, value):
+			changes = append(changes, bson.M{"op": "replace", "path": path, "value": value, "old": previous})
+		}
+	}
+
+	for path, previous := range old {
+		if _, ok := updated[path]; !ok {
+			changes = append(changes, bson.M{"op": "remove", "path": path, "old": previous})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i]["path"].(string) < changes[j]["path"].(string)
+	})
+	return changes
+}
+
+// flattenConfig collects the scalar leaves of a decoded config by path.
+// Empty objects and lists are leaves too, so adding one is a change.
+func flattenConfig(v interface{}, prefix string, leaves map[string]interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 && prefix != "" {
+			leaves[prefix] = bson.M{}
+		}
+		for key, value := range v {
+			flattenConfig(value, prefix+"/"+key, leaves)
+		}
+
+	case []interface{}:
+		if len(v) == 0 {
+			leaves[prefix] = bson.A{}
+		}
+		for i, value := range v {
+			flattenConfig(value, prefix+"/"+strconv.Itoa(i), leaves)
+		}
+
+	case nil:
+		if prefix != "" {
+			leaves[prefix] = nil
+		}
+
+	default:
+		leaves[prefix] = normalizeNumbers(v)
+	}
+}
+
+// parseAuditOption parses the mongo_log_audit global option:
+//
+//	mongo_log_audit [<writer>] {
+//	    writer     <name>
+//	    collection <name>
+//	    admin      <address>
+//	}
+func parseAuditOption(d *caddyfile.Dispenser, _ any) (any, error) {
+	a := new(MongoLogAudit)
+
+	d.Next() // consume option name
+	if d.NextArg() {
+		a.Writer = d.Val()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		option := d.Val()
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+
+		switch option {
+		case "writer":
+			a.Writer = d.Val()
+		case "collection":
+			a.Collection = d.Val()
+		case "admin":
+			a.Admin = d.Val()
+		default:
+			return nil, d.Errf("unrecognized mongo_log_audit option %q", option)
+		}
+	}
+
+	return httpcaddyfile.App{
+		Name:  "mongo_log_audit",
+		Value: caddyconfig.JSON(a, nil),
+	}, nil
+}
+
+// Interface guards.
+var (
+	_ caddy.App         = (*MongoLogAudit)(nil)
+	_ caddy.Provisioner = (*MongoLogAudit)(nil)
+)
@@ -0,0 +1,108 @@
+package mongo_log
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	authSCRAMSHA1   = "SCRAM-SHA-1"
+	authSCRAMSHA256 = "SCRAM-SHA-256"
+	authX509        = "MONGODB-X509"
+	authAWS         = "MONGODB-AWS"
+)
+
+func (l *MongoLog) validateAuth() error {
+	switch l.AuthMechanism {
+	case "":
+	case authSCRAMSHA1, authSCRAMSHA256:
+		if l.Username == "" {
+			return fmt.Errorf("auth_mechanism %s requires a username", l.AuthMechanism)
+		}
+	case authX509:
+		if l.CertFile == "" && (l.TLS == nil || l.TLS.CertFile == "") {
+			return fmt.Errorf("auth_mechanism %s requires cert_file and key_file", authX509)
+		}
+	case authAWS:
+	case authGSSAPI:
+		if l.Username == "" {
+			return fmt.Errorf("auth_mechanism %s requires the principal as username", authGSSAPI)
+		}
+	default:
+		return fmt.Errorf("unsupported auth_mechanism %q", l.AuthMechanism)
+	}
+
+	if l.Kerberos != nil && l.AuthMechanism != authGSSAPI {
+		return fmt.Errorf("kerberos requires auth_mechanism %s", authGSSAPI)
+	}
+
+	if l.AuthSource != "" && l.AuthSource != "$external" && (l.AuthMechanism == authX509 || l.AuthMechanism == authAWS || l.AuthMechanism == authGSSAPI) {
+		return fmt.Errorf("auth_mechanism %s authenticates against $external, not %q", l.AuthMechanism, l.AuthSource)
+	}
+
+	if (l.CertFile == "") != (l.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
+
+	return nil
+}
+
+// loadClientCert adds the cert_file/key_file pair to the TLS config so it
+// is presented for X.509 authentication.
+func (l *MongoLog) loadClientCert() error {
+	if l.CertFile == "" {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading client certificate: %v", err)
+	}
+
+	if l.tlsConfig == nil {
+		l.tlsConfig = &tls.Config{}
+	}
+	l.tlsConfig.Certificates = append(l.tlsConfig.Certificates, cert)
+
+	return nil
+}
+
+// credential returns the credential for the configured auth mechanism, or
+// nil to leave authentication to the connection string.
+func (l *MongoLog) credential() *options.Credential {
+	cred := &options.Credential{
+		Username:    l.Username,
+		Password:    l.Password,
+		PasswordSet: l.Password != "",
+		AuthSource:  l.AuthSource,
+	}
+
+	switch l.AuthMechanism {
+	case authSCRAMSHA1, authSCRAMSHA256:
+		cred.AuthMechanism = l.AuthMechanism
+
+	case authX509:
+		cred.AuthMechanism = authX509
+		cred.AuthSource = "$external"
+
+	case authGSSAPI:
+		cred.AuthMechanism = authGSSAPI
+		cred.AuthSource = "$external"
+		cred.AuthMechanismProperties = l.Kerberos.mechanismProperties()
+
+	case authAWS:
+		// Without a username the driver resolves credentials itself: the
+		// AWS_* env vars, then the ECS task role, then EC2 instance metadata.
+		cred.AuthMechanism = authAWS
+		cred.AuthSource = "$external"
+
+	default:
+		if l.Username == "" {
+			return nil
+		}
+	}
+
+	return cred
+}
@@ -0,0 +1,207 @@
+package mongo_log
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBatchSize       = 100
+	defaultFlushInterval   = time.Second
+	defaultShutdownTimeout = 10 * time.Second
+)
+
+type pendingDoc struct {
+	target target
+	doc    interface{}
+}
+
+// add appends a document to the pending batch and flushes it once
+// batchSize documents have accumulated.
+func (mWrite *mongoWriter) add(t target, doc interface{}) {
+	if mWrite.appendDoc(t, doc) {
+		mWrite.flush()
+	}
+}
+
+// appendDoc appends a document to the pending batch and reports whether
+// the batch is full.
+func (mWrite *mongoWriter) appendDoc(t target, doc interface{}) bool {
+	mWrite.mu.Lock()
+	defer mWrite.mu.Unlock()
+
+	mWrite.batch = append(mWrite.batch, pendingDoc{target: t, doc: doc})
+	return len(mWrite.batch) >= mWrite.batchSize
+}
+
+// flush inserts all pending documents with one InsertMany per target.
+func (mWrite *mongoWriter) flush() error {
+	mWrite.mu.Lock()
+	pending := mWrite.batch
+	mWrite.batch = nil
+	mWrite.mu.Unlock()
+
+	var targets []target
+	groups := map[target][]interface{}{}
+	for _, p := range pending {
+		if _, ok := groups[p.target]; !ok {
+			targets = append(targets, p.target)
+		}
+		groups[p.target] = append(groups[p.target], p.doc)
+	}
+
+	var firstErr error
+	for _, t := range targets {
+		if err := mWrite.insert(t, groups[t]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// retryableWriteCodes are per-document write errors worth retrying. Any
+// other code means the server rejected the document itself, e.g. for its
+// size or a failed validation.
+var retryableWriteCodes = map[int]bool{
+	50:    true, // MaxTimeMSExpired
+	91:    true, // ShutdownInProgress
+	189:   true, // PrimarySteppedDown
+	262:   true, // ExceededTimeLimit
+	10107: true, // NotWritablePrimary
+	11600: true, // InterruptedAtShutdown
+	11602: true, // InterruptedDueToReplStateChange
+	13435: true, // NotPrimaryNoSecondaryOk
+	13436: true, // NotPrimaryOrSecondary
+}
+
+// insert writes docs to t without ordering, so one rejected document does
+// not fail the others. Rejected documents are dead-lettered; the rest are
+// retried with backoff and spooled to disk if every attempt fails.
+func (mWrite *mongoWriter) insert(t target, docs []interface{}) error {
+	err := errBreakerOpen
+	for attempt := 0; attempt <= mWrite.maxRetries && mWrite.allowInsert(); attempt++ {
+		if attempt > 0 && !mWrite.sleep(backoff(mWrite.retryInterval, attempt-1)) {
+			break
+		}
+
+		mWrite.insertAttempts.Add(1)
+
+		var collection *mongo.Collection
+		if collection, err = mWrite.collectionFor(t); err != nil {
+			mWrite.failedAttempts.Add(1)
+			mWrite.insertFailed()
+			continue
+		}
+
+		start := time.Now()
+		err = mWrite.writeDocs(context.Background(), collection, docs)
+
+		if threshold := time.Duration(mWrite.config.SlowInsertThreshold); threshold > 0 && time.Since(start) > threshold {
+			mWrite.logger.Warn("Slow mongo insert",
+				zap.String("collection", t.collection),
+				zap.Int("count", len(docs)),
+				zap.Duration("latency", time.Since(start)),
+				zap.Error(err))
+		}
+
+		if err == nil {
+			mWrite.recordInserted(len(docs), start)
+			mWrite.insertSucceeded()
+			return nil
+		}
+
+		var bwe mongo.BulkWriteException
+		if errors.As(err, &bwe) && bwe.WriteConcernError == nil && len(bwe.WriteErrors) > 0 {
+			retry := mWrite.splitRejected(t, docs, bwe.WriteErrors)
+			mWrite.recordInserted(len(docs)-len(bwe.WriteErrors), start)
+
+			if docs = retry; len(docs) == 0 {
+				mWrite.insertSucceeded()
+				return nil
+			}
+		}
+
+		mWrite.insertErrors.Add(1)
+		mWrite.failedAttempts.Add(1)
+		mongoMetrics.insertErrors.WithLabelValues(mWrite.metricsLabel).Inc()
+		mWrite.insertFailed()
+	}
+
+	if mWrite.fallback != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), fallbackTimeout)
+		fallbackErr := mWrite.fallback.publish(ctx, t, docs)
+		cancel()
+
+		if fallbackErr == nil {
+			mWrite.logger.Warn("InsertMany failed, published log batch to fallback", zap.Int("count", len(docs)), zap.Error(err))
+			return nil
+		}
+		mWrite.logger.Error("Could not publish log batch to fallback", zap.Error(fallbackErr))
+	}
+
+	if mWrite.spoolDir != "" {
+		spoolErr := mWrite.spool(t, docs)
+		if spoolErr == nil {
+			mWrite.logger.Warn("InsertMany failed, spooled log batch to disk", zap.Int("count", len(docs)), zap.Error(err))
+			return nil
+		}
+		mWrite.logger.Error("Could not spool log batch", zap.Error(spoolErr))
+	}
+
+	mWrite.logger.Error("InsertMany failed on log batch", zap.String("collection", t.collection), zap.Int("count", len(docs)), zap.Error(err))
+	return err
+}
+
+// splitRejected dead-letters the documents of a partially failed insert
+// that the server rejected and returns those worth retrying.
+func (mWrite *mongoWriter) splitRejected(t target, docs []interface{}, writeErrors []mongo.BulkWriteError) []interface{} {
+	var retry []interface{}
+	for _, we := range writeErrors {
+		if we.Index < 0 || we.Index >= len(docs) {
+			continue
+		}
+
+		if retryableWriteCodes[we.Code] {
+			retry = append(retry, docs[we.Index])
+			continue
+		}
+		mWrite.rejected(t, docs[we.Index], we.WriteError)
+	}
+
+	return retry
+}
+
+func (mWrite *mongoWriter) recordInserted(n int, start time.Time) {
+	if n <= 0 {
+		return
+	}
+
+	mWrite.inserted.Add(uint64(n))
+	mWrite.lastInsert.Store(time.Now().UnixNano())
+	mongoMetrics.inserts.WithLabelValues(mWrite.metricsLabel).Add(float64(n))
+	mongoMetrics.batchSize.WithLabelValues(mWrite.metricsLabel).Observe(float64(n))
+	mongoMetrics.insertLatency.WithLabelValues(mWrite.metricsLabel).Observe(time.Since(start).Seconds())
+}
+
+// flushLoop flushes the pending batch every flushInterval until done is closed.
+func (mWrite *mongoWriter) flushLoop() {
+	defer mWrite.wg.Done()
+
+	ticker := time.NewTicker(mWrite.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			mongoMetrics.queueDepth.WithLabelValues(mWrite.metricsLabel).Set(float64(len(mWrite.queue)))
+			mWrite.flush()
+		case <-mWrite.done:
+			return
+		}
+	}
+}
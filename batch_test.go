@@ -0,0 +1,164 @@
+package mongo_log
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+var testTime = time.Date(2024, time.October, 14, 12, 0, 0, 0, time.UTC)
+
+// newTestWriter returns a writer for l that is not connected to mongo.
+func newTestWriter(l *MongoLog) *mongoWriter {
+	mongoMetrics.init.Do(initMongoMetrics)
+
+	if l.Database == "" {
+		l.Database = "logs"
+	}
+	if l.Collection == "" {
+		l.Collection = "caddy"
+	}
+
+	return &mongoWriter{
+		logger:       zap.NewNop(),
+		config:       l,
+		metricsLabel: "test",
+		collections:  map[target]*mongo.Collection{},
+		batchSize:    defaultBatchSize,
+		done:         make(chan struct{}),
+	}
+}
+
+func TestAppendDoc(t *testing.T) {
+	mWrite := newTestWriter(&MongoLog{})
+	mWrite.batchSize = 3
+	main := mWrite.config.target(nil, testTime)
+
+	for i, wantFull := range []bool{false, false, true, true} {
+		if full := mWrite.appendDoc(main, bson.M{"n": i}); full != wantFull {
+			t.Errorf("appendDoc #%d: full = %v, want %v", i, full, wantFull)
+		}
+	}
+
+	if len(mWrite.batch) != 4 {
+		t.Errorf("batch holds %d documents, want 4", len(mWrite.batch))
+	}
+}
+
+func TestSplitRejected(t *testing.T) {
+	docs := []interface{}{bson.M{"n": 0}, bson.M{"n": 1}, bson.M{"n": 2}}
+
+	writeError := func(index, code int) mongo.BulkWriteError {
+		return mongo.BulkWriteError{WriteError: mongo.WriteError{Index: index, Code: code, Message: "failed"}}
+	}
+
+	tests := []struct {
+		name        string
+		target      target
+		writeErrors []mongo.BulkWriteError
+		wantRetry   []interface{}
+		wantDead    int
+		wantDropped uint64
+	}{
+		{
+			name:        "retryable",
+			target:      target{database: "logs", collection: "caddy"},
+			writeErrors: []mongo.BulkWriteError{writeError(0, 91), writeError(2, 189)},
+			wantRetry:   []interface{}{docs[0], docs[2]},
+		},
+		{
+			name:        "rejected",
+			target:      target{database: "logs", collection: "caddy"},
+			writeErrors: []mongo.BulkWriteError{writeError(1, 121), writeError(2, 10334)},
+			wantDead:    2,
+		},
+		{
+			name:        "mixed",
+			target:      target{database: "logs", collection: "caddy"},
+			writeErrors: []mongo.BulkWriteError{writeError(0, 11600), writeError(1, 121)},
+			wantRetry:   []interface{}{docs[0]},
+			wantDead:    1,
+		},
+		{
+			name:        "index out of range",
+			target:      target{database: "logs", collection: "caddy"},
+			writeErrors: []mongo.BulkWriteError{writeError(-1, 91), writeError(3, 121)},
+		},
+		{
+			name:        "rejected by dead letter collection",
+			target:      target{database: "logs", collection: "caddy_dead_letter", auxiliary: true},
+			writeErrors: []mongo.BulkWriteError{writeError(0, 121)},
+			wantDropped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mWrite := newTestWriter(&MongoLog{DeadLetter: "caddy_dead_letter"})
+
+			retry := mWrite.splitRejected(tt.target, docs, tt.writeErrors)
+
+			if len(retry) != len(tt.wantRetry) {
+				t.Fatalf("retry = %v, want %v", retry, tt.wantRetry)
+			}
+			for i := range retry {
+				if retry[i].(bson.M)["n"] != tt.wantRetry[i].(bson.M)["n"] {
+					t.Errorf("retry[%d] = %v, want %v", i, retry[i], tt.wantRetry[i])
+				}
+			}
+
+			if len(mWrite.batch) != tt.wantDead {
+				t.Fatalf("%d dead letters batched, want %d", len(mWrite.batch), tt.wantDead)
+			}
+			for _, p := range mWrite.batch {
+				want := target{database: "logs", collection: "caddy_dead_letter", auxiliary: true}
+				if p.target != want {
+					t.Errorf("dead letter target = %+v, want %+v", p.target, want)
+				}
+				if _, ok := p.doc.(bson.M)["raw"].(string); !ok {
+					t.Errorf("dead letter has no raw document: %v", p.doc)
+				}
+			}
+
+			if dropped := mWrite.dropped.Load(); dropped != tt.wantDropped {
+				t.Errorf("dropped = %d, want %d", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestInsertNotConnected(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxRetries   int
+		breaker      *Breaker
+		wantAttempts uint64
+	}{
+		{name: "no retries", wantAttempts: 1},
+		{name: "breaker open", maxRetries: 3, breaker: &Breaker{Failures: 1, Cooldown: caddy.Duration(time.Minute)}, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mWrite := newTestWriter(&MongoLog{Breaker: tt.breaker})
+			mWrite.maxRetries = tt.maxRetries
+
+			err := mWrite.insert(mWrite.config.target(nil, testTime), []interface{}{bson.M{"n": 0}})
+			if !errors.Is(err, errNotConnected) {
+				t.Errorf("insert error = %v, want %v", err, errNotConnected)
+			}
+
+			if attempts := mWrite.insertAttempts.Load(); attempts != tt.wantAttempts {
+				t.Errorf("%d insert attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if failed := mWrite.failedAttempts.Load(); failed != tt.wantAttempts {
+				t.Errorf("%d failed attempts, want %d", failed, tt.wantAttempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package mongo_log
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
+	"go.uber.org/zap"
+)
+
+const defaultMaxBodySize = 64 << 10
+
+var defaultContentTypes = []string{"text/*", "application/json", "application/xml", "application/x-www-form-urlencoded"}
+
+func init() {
+	caddy.RegisterModule(MongoBody{})
+	httpcaddyfile.RegisterHandlerDirective("mongo_body", parseBodyCaddyfile)
+	// inside encode, so captured responses are not compressed, and around
+	// every handler that responds
+	httpcaddyfile.RegisterDirectiveOrder("mongo_body", httpcaddyfile.After, "encode")
+}
+
+// MongoBody captures the request body, up to MaxBodySize bytes, into the
+// access log entry as req_body and the {http.mongo_body.request}
+// placeholder. The body is restored for the next handler.
+type MongoBody struct {
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+
+	// Also capture response bodies as resp_body, up to MaxResponseSize
+	// bytes. The response is streamed to the client as usual.
+	Response        bool  `json:"response,omitempty"`
+	MaxResponseSize int64 `json:"max_response_size,omitempty"`
+
+	// Media types of the bodies captured, which may end in "/*". Other
+	// bodies, such as binary uploads, are only logged as req_body_type and
+	// req_body_size, or resp_body_type and resp_body_size.
+	ContentTypes []string `json:"content_types,omitempty"`
+
+	// Only log the bodies of responses with at least this status, e.g.
+	// 500. They are still buffered for every request.
+	MinStatus int `json:"min_status,omitempty"`
+}
+
+// CaddyModule implements caddy.Module.
+func (MongoBody) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.mongo_body",
+		New: func() caddy.Module { return new(MongoBody) },
+	}
+}
+
+func (m *MongoBody) Provision(ctx caddy.Context) error {
+	if m.MaxBodySize <= 0 {
+		m.MaxBodySize = defaultMaxBodySize
+	}
+
+	if m.MaxResponseSize <= 0 {
+		m.MaxResponseSize = defaultMaxBodySize
+	}
+
+	if len(m.ContentTypes) == 0 {
+		m.ContentTypes = defaultContentTypes
+	}
+	return nil
+}
+
+func (m MongoBody) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	extra, _ := r.Context().Value(caddyhttp.ExtraLogFieldsCtxKey).(*caddyhttp.ExtraLogFields)
+	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+
+	var req *capturedBody
+	if r.Body != nil && r.Body != http.NoBody {
+		if req = m.captureRequest(r); !req.skipped {
+			repl.Set("http.mongo_body.request", req.body)
+		}
+	}
+
+	if !m.Response && m.MinStatus == 0 {
+		req.log(extra, "req_body")
+		return next.ServeHTTP(w, r)
+	}
+
+	rc := &responseCapture{
+		ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w},
+		enabled:               m.Response,
+		limit:                 m.MaxResponseSize,
+		contentTypes:          m.ContentTypes,
+	}
+	err := next.ServeHTTP(rc, r)
+
+	var resp *capturedBody
+	switch {
+	case rc.capture:
+		resp = &capturedBody{body: rc.buf.String(), truncated: rc.truncated}
+		repl.Set("http.mongo_body.response", resp.body)
+	case rc.enabled && rc.size > 0:
+		resp = &capturedBody{skipped: true, contentType: rc.Header().Get("Content-Type"), size: rc.size}
+	}
+
+	if m.MinStatus > 0 && responseStatus(rc, err) < m.MinStatus {
+		return err
+	}
+
+	req.log(extra, "req_body")
+	resp.log(extra, "resp_body")
+
+	return err
+}
+
+// responseStatus is the status the client gets: the one written, or the
+// one of the error the error routes will handle.
+func responseStatus(rc *responseCapture, err error) int {
+	if err != nil {
+		var handlerErr caddyhttp.HandlerError
+		if errors.As(err, &handlerErr) && handlerErr.StatusCode != 0 {
+			return handlerErr.StatusCode
+		}
+		return http.StatusInternalServerError
+	}
+
+	if rc.status == 0 {
+		return http.StatusOK
+	}
+	return rc.status
+}
+
+// capturedBody is the start of a request or response body, or only its
+// type and size if the type is not captured.
+type capturedBody struct {
+	body      string
+	truncated bool
+
+	skipped     bool
+	contentType string
+	size        int64
+}
+
+// log adds the body to the access log entry as field, and field_truncated
+// if it was cut short.
+func (b *capturedBody) log(extra *caddyhttp.ExtraLogFields, field string) {
+	if b == nil || extra == nil {
+		return
+	}
+
+	if b.skipped {
+		extra.Set(zap.String(field+"_type", b.contentType))
+		if b.size >= 0 {
+			extra.Set(zap.Int64(field+"_size", b.size))
+		}
+		return
+	}
+
+	extra.Set(zap.String(field, b.body))
+	if b.truncated {
+		extra.Set(zap.Bool(field+"_truncated", true))
+	}
+}
+
+func (m MongoBody) captureRequest(r *http.Request) *capturedBody {
+	if contentType := r.Header.Get("Content-Type"); !matchContentType(contentType, m.ContentTypes) {
+		// ContentLength is -1 when unknown, e.g. for chunked uploads
+		return &capturedBody{skipped: true, contentType: contentType, size: r.ContentLength}
+	}
+
+	// read one byte past the limit to tell whether the body was cut short
+	captured, err := io.ReadAll(io.LimitReader(r.Body, m.MaxBodySize+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(captured), errReader{err}, r.Body), r.Body}
+
+	truncated := int64(len(captured)) > m.MaxBodySize
+	if truncated {
+		captured = captured[:m.MaxBodySize]
+	}
+
+	return &capturedBody{body: string(captured), truncated: truncated}
+}
+
+func (m *MongoBody) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "max_body_size":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				size, err := humanize.ParseBytes(d.Val())
+				if err != nil {
+					return d.Errf("invalid max_body_size %q: %v", d.Val(), err)
+				}
+				m.MaxBodySize = int64(size)
+
+			case "response":
+				m.Response = true
+
+			case "max_response_size":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				size, err := humanize.ParseBytes(d.Val())
+				if err != nil {
+					return d.Errf("invalid max_response_size %q: %v", d.Val(), err)
+				}
+				m.MaxResponseSize = int64(size)
+				m.Response = true
+
+			case "content_types":
+				types := d.RemainingArgs()
+				if len(types) == 0 {
+					return d.ArgErr()
+				}
+				m.ContentTypes = append(m.ContentTypes, types...)
+
+			case "min_status":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				status, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid min_status %q: %v", d.Val(), err)
+				}
+				m.MinStatus = status
+
+			default:
+				return d.Errf("unrecognized mongo_body option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseBodyCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	m := new(MongoBody)
+	err := m.UnmarshalCaddyfile(h.Dispenser)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// readCloser reads from a replacement reader but closes the original body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// errReader replays an error hit while capturing the body, once the
+// captured bytes have been consumed.
+type errReader struct {
+	err error
+}
+
+func (e errReader) Read(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	return 0, io.EOF
+}
+
+// responseCapture copies the start of a response body aside while it is
+// written through to the client.
+type responseCapture struct {
+	*caddyhttp.ResponseWriterWrapper
+	enabled      bool
+	limit        int64
+	contentTypes []string
+
+	wroteHeader bool
+	status      int
+	capture     bool
+	truncated   bool
+	buf         bytes.Buffer
+	size        int64
+}
+
+func (rc *responseCapture) WriteHeader(status int) {
+	// informational responses are followed by the real header
+	if !rc.wroteHeader && status >= 200 {
+		rc.wroteHeader = true
+		rc.status = status
+		rc.capture = rc.enabled && matchContentType(rc.Header().Get("Content-Type"), rc.contentTypes)
+	}
+	rc.ResponseWriterWrapper.WriteHeader(status)
+}
+
+func (rc *responseCapture) Write(p []byte) (int, error) {
+	if !rc.wroteHeader {
+		rc.WriteHeader(http.StatusOK)
+	}
+
+	rc.size += int64(len(p))
+
+	if rc.capture {
+		room := rc.limit - int64(rc.buf.Len())
+		if int64(len(p)) > room {
+			rc.truncated = true
+		}
+		if room > 0 {
+			rc.buf.Write(p[:min(int64(len(p)), room)])
+		}
+	}
+
+	return rc.ResponseWriterWrapper.Write(p)
+}
+
+// ReadFrom keeps io.Copy from bypassing Write.
+func (rc *responseCapture) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{rc}, r)
+}
+
+// matchContentType reports whether the media type of a Content-Type header
+// matches one of patterns, which may end in "/*".
+func matchContentType(header string, patterns []string) bool {
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Interface guards.
+var (
+	_ caddy.Provisioner           = (*MongoBody)(nil)
+	_ caddyhttp.MiddlewareHandler = (*MongoBody)(nil)
+	_ caddyfile.Unmarshaler       = (*MongoBody)(nil)
+)
@@ -0,0 +1,162 @@
+package mongo_log
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+// Breaker stops insert attempts for Cooldown after Failures consecutive
+// failed ones, sending batches straight to the fallback or spool instead of
+// waiting on an unreachable server. After the cool-down a single insert
+// probes whether mongo is back.
+type Breaker struct {
+	Failures int            `json:"failures,omitempty"`
+	Cooldown caddy.Duration `json:"cooldown,omitempty"`
+}
+
+func parseBreaker(d *caddyfile.Dispenser) (*Breaker, error) {
+	b := &Breaker{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "failures":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			failures, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid breaker failures %q: %v", d.Val(), err)
+			}
+			b.Failures = failures
+
+		case "cooldown":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			cooldown, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid breaker cooldown %q: %v", d.Val(), err)
+			}
+			b.Cooldown = caddy.Duration(cooldown)
+
+		default:
+			return nil, d.Errf("unrecognized breaker option %q", d.Val())
+		}
+	}
+
+	return b, nil
+}
+
+func (b *Breaker) provision() {
+	if b.Failures <= 0 {
+		b.Failures = defaultBreakerFailures
+	}
+	if b.Cooldown <= 0 {
+		b.Cooldown = caddy.Duration(defaultBreakerCooldown)
+	}
+}
+
+// breakerState tracks consecutive failures of a writer's inserts.
+type breakerState struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allowInsert reports whether an insert may be attempted: always while the
+// breaker is closed, and once per cool-down while it is open.
+func (mWrite *mongoWriter) allowInsert() bool {
+	if mWrite.config.Breaker == nil {
+		return true
+	}
+
+	b := &mWrite.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true
+	}
+
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+
+	b.probing = true
+	return true
+}
+
+func (mWrite *mongoWriter) insertSucceeded() {
+	if mWrite.config.Breaker == nil {
+		return
+	}
+
+	b := &mWrite.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.openUntil.IsZero() {
+		mWrite.logger.Info("Circuit breaker closed, mongo inserts resumed")
+		mongoMetrics.breakerOpen.WithLabelValues(mWrite.metricsLabel).Set(0)
+	}
+
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+}
+
+func (mWrite *mongoWriter) insertFailed() {
+	cfg := mWrite.config.Breaker
+	if cfg == nil {
+		return
+	}
+
+	b := &mWrite.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	wasOpen := !b.openUntil.IsZero()
+
+	if wasOpen || b.failures >= cfg.Failures {
+		b.openUntil = time.Now().Add(time.Duration(cfg.Cooldown))
+		b.probing = false
+
+		if !wasOpen {
+			mWrite.logger.Warn("Circuit breaker opened, pausing mongo inserts",
+				zap.Int("failures", b.failures), zap.Duration("cooldown", time.Duration(cfg.Cooldown)))
+			mongoMetrics.breakerOpen.WithLabelValues(mWrite.metricsLabel).Set(1)
+		}
+	}
+}
+
+func (mWrite *mongoWriter) breakerOpen() bool {
+	b := &mWrite.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.openUntil.IsZero()
+}
+
+func (b *Breaker) validate() error {
+	if b.Failures < 0 {
+		return fmt.Errorf("breaker failures must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package mongo_log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestBreaker(t *testing.T) {
+	// each step is followed by a check of whether the breaker is open;
+	// allow steps also check allowInsert, and cooldown ends the cool-down
+	type step struct {
+		do        string
+		wantAllow bool
+		wantOpen  bool
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens after consecutive failures",
+			steps: []step{
+				{do: "allow", wantAllow: true},
+				{do: "fail"},
+				{do: "allow", wantAllow: true},
+				{do: "fail", wantOpen: true},
+				{do: "allow", wantOpen: true},
+			},
+		},
+		{
+			name: "success resets the failure count",
+			steps: []step{
+				{do: "fail"},
+				{do: "succeed"},
+				{do: "fail"},
+				{do: "allow", wantAllow: true},
+			},
+		},
+		{
+			name: "single probe after the cool-down",
+			steps: []step{
+				{do: "fail"},
+				{do: "fail", wantOpen: true},
+				{do: "cooldown", wantOpen: true},
+				{do: "allow", wantAllow: true, wantOpen: true},
+				{do: "allow", wantOpen: true},
+			},
+		},
+		{
+			name: "failed probe restarts the cool-down",
+			steps: []step{
+				{do: "fail"},
+				{do: "fail", wantOpen: true},
+				{do: "cooldown", wantOpen: true},
+				{do: "allow", wantAllow: true, wantOpen: true},
+				{do: "fail", wantOpen: true},
+				{do: "allow", wantOpen: true},
+			},
+		},
+		{
+			name: "successful probe closes the breaker",
+			steps: []step{
+				{do: "fail"},
+				{do: "fail", wantOpen: true},
+				{do: "cooldown", wantOpen: true},
+				{do: "allow", wantAllow: true, wantOpen: true},
+				{do: "succeed"},
+				{do: "allow", wantAllow: true},
+				{do: "fail"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mWrite := newTestWriter(&MongoLog{Breaker: &Breaker{Failures: 2, Cooldown: caddy.Duration(time.Hour)}})
+
+			for i, s := range tt.steps {
+				switch s.do {
+				case "allow":
+					if allow := mWrite.allowInsert(); allow != s.wantAllow {
+						t.Fatalf("step %d: allowInsert = %v, want %v", i, allow, s.wantAllow)
+					}
+				case "fail":
+					mWrite.insertFailed()
+				case "succeed":
+					mWrite.insertSucceeded()
+				case "cooldown":
+					mWrite.breaker.openUntil = time.Now().Add(-time.Millisecond)
+				}
+
+				if open := mWrite.breakerOpen(); open != s.wantOpen {
+					t.Fatalf("step %d (%s): breakerOpen = %v, want %v", i, s.do, open, s.wantOpen)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	mWrite := newTestWriter(&MongoLog{})
+
+	for i := 0; i < defaultBreakerFailures*2; i++ {
+		mWrite.insertFailed()
+	}
+
+	if !mWrite.allowInsert() || mWrite.breakerOpen() {
+		t.Error("breaker opened without a breaker block")
+	}
+}
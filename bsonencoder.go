@@ -0,0 +1,349 @@
+package mongo_log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+func init() {
+	caddy.RegisterModule(BSONEncoder{})
+}
+
+// BSONEncoder encodes entries as BSON documents for the mongo_log writer,
+// skipping the JSON round trip: integers stay integers, ts is a date and
+// binary fields stay binary. Durations are seconds like Caddy's default.
+// Its output is only meant for mongo_log; other writers get raw BSON.
+type BSONEncoder struct {
+	zapcore.Encoder `json:"-"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (BSONEncoder) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "caddy.logging.encoders.mongo_bson",
+		New: func() caddy.Module { return new(BSONEncoder) },
+	}
+}
+
+// Provision sets up the encoder.
+func (be *BSONEncoder) Provision(_ caddy.Context) error {
+	be.Encoder = &bsonEncoder{}
+	return nil
+}
+
+// UnmarshalCaddyfile sets up the module from Caddyfile tokens. Syntax:
+//
+//	mongo_bson
+func (be *BSONEncoder) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume encoder name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	if d.NextBlock(0) {
+		return d.Errf("unrecognized subdirective %s", d.Val())
+	}
+	return nil
+}
+
+var bsonPool = buffer.NewPool()
+
+// bsonEncoder appends elements to buf, which holds the fields added with
+// With. namespaces are the offsets of the documents OpenNamespace started.
+type bsonEncoder struct {
+	buf        []byte
+	namespaces []int32
+}
+
+func (e *bsonEncoder) Clone() zapcore.Encoder {
+	return &bsonEncoder{
+		buf:        append([]byte(nil), e.buf...),
+		namespaces: append([]int32(nil), e.namespaces...),
+	}
+}
+
+// EncodeEntry lays the entry out like Caddy's JSON encoder: level, ts,
+// logger, caller and msg, then the context and entry fields, then
+// stacktrace.
+func (e *bsonEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	start, doc := bsoncore.AppendDocumentStart(nil)
+	doc = bsoncore.AppendStringElement(doc, "level", ent.Level.String())
+	doc = bsoncore.AppendDateTimeElement(doc, "ts", ent.Time.UnixMilli())
+	if ent.LoggerName != "" {
+		doc = bsoncore.AppendStringElement(doc, "logger", ent.LoggerName)
+	}
+	if ent.Caller.Defined {
+		doc = bsoncore.AppendStringElement(doc, "caller", ent.Caller.TrimmedPath())
+	}
+	doc = bsoncore.AppendStringElement(doc, "msg", ent.Message)
+
+	final := &bsonEncoder{buf: append(doc, e.buf...)}
+	for _, ns := range e.namespaces {
+		final.namespaces = append(final.namespaces, ns+int32(len(doc)))
+	}
+	for _, field := range fields {
+		field.AddTo(final)
+	}
+	final.closeNamespaces(0)
+
+	if ent.Stack != "" {
+		final.AddString("stacktrace", ent.Stack)
+	}
+
+	out, err := bsoncore.AppendDocumentEnd(final.buf, start)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bsonPool.Get()
+	buf.Write(out)
+	return buf, nil
+}
+
+// closeNamespaces ends the namespaces opened after the first n.
+func (e *bsonEncoder) closeNamespaces(n int) {
+	for i := len(e.namespaces) - 1; i >= n; i-- {
+		e.buf, _ = bsoncore.AppendDocumentEnd(e.buf, e.namespaces[i])
+	}
+	e.namespaces = e.namespaces[:n]
+}
+
+func (e *bsonEncoder) OpenNamespace(key string) {
+	var start int32
+	start, e.buf = bsoncore.AppendDocumentElementStart(e.buf, key)
+	e.namespaces = append(e.namespaces, start)
+}
+
+func (e *bsonEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	start, buf := bsoncore.AppendDocumentElementStart(e.buf, key)
+	e.buf = buf
+
+	// namespaces opened by the marshaler end with its object
+	open := len(e.namespaces)
+	err := obj.MarshalLogObject(e)
+	e.closeNamespaces(open)
+
+	e.buf, _ = bsoncore.AppendDocumentEnd(e.buf, start)
+	return err
+}
+
+func (e *bsonEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	start, buf := bsoncore.AppendArrayElementStart(e.buf, key)
+	e.buf = buf
+
+	err := arr.MarshalLogArray(&bsonArrayEncoder{e: e})
+
+	e.buf, _ = bsoncore.AppendArrayEnd(e.buf, start)
+	return err
+}
+
+// AddReflected encodes v as encoding/json would, so struct fields keep
+// their JSON names.
+func (e *bsonEncoder) AddReflected(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return err
+	}
+
+	t, value, err := bson.MarshalValue(normalizeNumbers(decoded))
+	if err != nil {
+		return err
+	}
+
+	e.buf = append(bsoncore.AppendHeader(e.buf, t, key), value...)
+	return nil
+}
+
+func (e *bsonEncoder) AddBinary(key string, v []byte) {
+	e.buf = bsoncore.AppendBinaryElement(e.buf, key, bsontype.BinaryGeneric, v)
+}
+
+func (e *bsonEncoder) AddByteString(key string, v []byte) { e.AddString(key, string(v)) }
+
+func (e *bsonEncoder) AddBool(key string, v bool) {
+	e.buf = bsoncore.AppendBooleanElement(e.buf, key, v)
+}
+
+func (e *bsonEncoder) AddComplex128(key string, v complex128) {
+	e.AddString(key, strconv.FormatComplex(v, 'g', -1, 128))
+}
+
+func (e *bsonEncoder) AddComplex64(key string, v complex64) {
+	e.AddString(key, strconv.FormatComplex(complex128(v), 'g', -1, 64))
+}
+
+func (e *bsonEncoder) AddDuration(key string, v time.Duration) { e.AddFloat64(key, v.Seconds()) }
+
+func (e *bsonEncoder) AddFloat64(key string, v float64) {
+	e.buf = bsoncore.AppendDoubleElement(e.buf, key, v)
+}
+
+func (e *bsonEncoder) AddFloat32(key string, v float32) { e.AddFloat64(key, float64(v)) }
+
+func (e *bsonEncoder) AddInt64(key string, v int64) {
+	e.buf = bsoncore.AppendInt64Element(e.buf, key, v)
+}
+
+func (e *bsonEncoder) AddInt32(key string, v int32) {
+	e.buf = bsoncore.AppendInt32Element(e.buf, key, v)
+}
+
+func (e *bsonEncoder) AddInt(key string, v int)     { e.AddInt64(key, int64(v)) }
+func (e *bsonEncoder) AddInt16(key string, v int16) { e.AddInt32(key, int32(v)) }
+func (e *bsonEncoder) AddInt8(key string, v int8)   { e.AddInt32(key, int32(v)) }
+
+func (e *bsonEncoder) AddString(key string, v string) {
+	e.buf = bsoncore.AppendStringElement(e.buf, key, v)
+}
+
+func (e *bsonEncoder) AddTime(key string, v time.Time) {
+	e.buf = bsoncore.AppendDateTimeElement(e.buf, key, v.UnixMilli())
+}
+
+// AddUint64 stores values past the int64 range as decimals, since BSON has
+// no unsigned integers.
+func (e *bsonEncoder) AddUint64(key string, v uint64) {
+	if v <= math.MaxInt64 {
+		e.AddInt64(key, int64(v))
+		return
+	}
+
+	d, _ := primitive.ParseDecimal128(strconv.FormatUint(v, 10))
+	e.buf = bsoncore.AppendDecimal128Element(e.buf, key, d)
+}
+
+func (e *bsonEncoder) AddUint(key string, v uint)       { e.AddUint64(key, uint64(v)) }
+func (e *bsonEncoder) AddUint32(key string, v uint32)   { e.AddInt64(key, int64(v)) }
+func (e *bsonEncoder) AddUint16(key string, v uint16)   { e.AddInt32(key, int32(v)) }
+func (e *bsonEncoder) AddUint8(key string, v uint8)     { e.AddInt32(key, int32(v)) }
+func (e *bsonEncoder) AddUintptr(key string, v uintptr) { e.AddUint64(key, uint64(v)) }
+
+// bsonArrayEncoder appends elements to its encoder's buf keyed by index,
+// as BSON arrays are.
+type bsonArrayEncoder struct {
+	e *bsonEncoder
+	n int
+}
+
+func (a *bsonArrayEncoder) key() string {
+	k := strconv.Itoa(a.n)
+	a.n++
+	return k
+}
+
+func (a *bsonArrayEncoder) AppendObject(v zapcore.ObjectMarshaler) error {
+	return a.e.AddObject(a.key(), v)
+}
+
+func (a *bsonArrayEncoder) AppendArray(v zapcore.ArrayMarshaler) error {
+	return a.e.AddArray(a.key(), v)
+}
+
+func (a *bsonArrayEncoder) AppendReflected(v interface{}) error {
+	return a.e.AddReflected(a.key(), v)
+}
+
+func (a *bsonArrayEncoder) AppendBool(v bool)              { a.e.AddBool(a.key(), v) }
+func (a *bsonArrayEncoder) AppendByteString(v []byte)      { a.e.AddByteString(a.key(), v) }
+func (a *bsonArrayEncoder) AppendComplex128(v complex128)  { a.e.AddComplex128(a.key(), v) }
+func (a *bsonArrayEncoder) AppendComplex64(v complex64)    { a.e.AddComplex64(a.key(), v) }
+func (a *bsonArrayEncoder) AppendDuration(v time.Duration) { a.e.AddDuration(a.key(), v) }
+func (a *bsonArrayEncoder) AppendFloat64(v float64)        { a.e.AddFloat64(a.key(), v) }
+func (a *bsonArrayEncoder) AppendFloat32(v float32)        { a.e.AddFloat32(a.key(), v) }
+func (a *bsonArrayEncoder) AppendInt(v int)                { a.e.AddInt(a.key(), v) }
+func (a *bsonArrayEncoder) AppendInt64(v int64)            { a.e.AddInt64(a.key(), v) }
+func (a *bsonArrayEncoder) AppendInt32(v int32)            { a.e.AddInt32(a.key(), v) }
+func (a *bsonArrayEncoder) AppendInt16(v int16)            { a.e.AddInt16(a.key(), v) }
+func (a *bsonArrayEncoder) AppendInt8(v int8)              { a.e.AddInt8(a.key(), v) }
+func (a *bsonArrayEncoder) AppendString(v string)          { a.e.AddString(a.key(), v) }
+func (a *bsonArrayEncoder) AppendTime(v time.Time)         { a.e.AddTime(a.key(), v) }
+func (a *bsonArrayEncoder) AppendUint(v uint)              { a.e.AddUint(a.key(), v) }
+func (a *bsonArrayEncoder) AppendUint64(v uint64)          { a.e.AddUint64(a.key(), v) }
+func (a *bsonArrayEncoder) AppendUint32(v uint32)          { a.e.AddUint32(a.key(), v) }
+func (a *bsonArrayEncoder) AppendUint16(v uint16)          { a.e.AddUint16(a.key(), v) }
+func (a *bsonArrayEncoder) AppendUint8(v uint8)            { a.e.AddUint8(a.key(), v) }
+func (a *bsonArrayEncoder) AppendUintptr(v uintptr)        { a.e.AddUintptr(a.key(), v) }
+
+// entryRegistry decodes BSON entries into the same shapes encoding/json
+// gives the rest of the pipeline: maps and []interface{}, not primitive.D
+// and primitive.A.
+var entryRegistry = func() *bsoncodec.Registry {
+	reg := bson.NewRegistry()
+	reg.RegisterTypeMapEntry(bsontype.EmbeddedDocument, reflect.TypeOf(map[string]interface{}{}))
+	reg.RegisterTypeMapEntry(bsontype.Array, reflect.TypeOf([]interface{}{}))
+	return reg
+}()
+
+// isBSON reports whether p is a document from BSONEncoder rather than a
+// line of JSON: its length prefix matches and it ends in a null byte,
+// where JSON entries end in a newline.
+func isBSON(p []byte) bool {
+	return len(p) >= 5 && int(binary.LittleEndian.Uint32(p)) == len(p) && p[len(p)-1] == 0
+}
+
+// isJSON reports whether p looks like a JSON object rather than a line
+// from the console or another plain-text encoder.
+func isJSON(p []byte) bool {
+	p = bytes.TrimLeft(p, " \t\r\n")
+	return len(p) > 0 && p[0] == '{'
+}
+
+// decodeEntry decodes a log entry written by BSONEncoder or a JSON encoder.
+// Any other line is kept whole as the entry's message, with a warning the
+// first time.
+func (mWrite *mongoWriter) decodeEntry(p []byte) (map[string]interface{}, error) {
+	f := map[string]interface{}{}
+
+	if !isBSON(p) && !isJSON(p) {
+		mWrite.plainTextOnce.Do(func() {
+			mWrite.logger.Warn("Log entries are not JSON, storing each line as a message; use format json or mongo_bson to keep their fields",
+				zap.String("collection", mWrite.config.Collection))
+		})
+
+		f["message"] = string(bytes.TrimRight(p, "\r\n"))
+		return f, nil
+	}
+
+	if isBSON(p) {
+		dec, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(p))
+		if err != nil {
+			return f, err
+		}
+		dec.SetRegistry(entryRegistry)
+		return f, dec.Decode(&f)
+	}
+
+	// encoding/json beats bson.UnmarshalExtJSON here: on a typical access
+	// log entry the latter is ~2.5x slower and allocates ~4x the memory,
+	// even decoding into bson.Raw; see the BenchmarkDecode benchmarks.
+	return f, json.Unmarshal(p, &f)
+}
+
+// Interface guards.
+var (
+	_ caddy.Provisioner     = (*BSONEncoder)(nil)
+	_ caddyfile.Unmarshaler = (*BSONEncoder)(nil)
+	_ zapcore.Encoder       = (*bsonEncoder)(nil)
+	_ zapcore.ArrayEncoder  = (*bsonArrayEncoder)(nil)
+)
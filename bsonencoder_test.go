@@ -0,0 +1,182 @@
+package mongo_log
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestBSONEncoder(t *testing.T) {
+	// opens a namespace it never closes, as zap.Namespace inside an object
+	// marshaler does
+	nested := zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("a", "1")
+		enc.OpenNamespace("inner")
+		enc.AddString("b", "2")
+		return nil
+	})
+
+	tests := []struct {
+		name   string
+		with   []zapcore.Field
+		fields []zapcore.Field
+		want   map[string]interface{}
+	}{
+		{
+			name:   "fields",
+			fields: []zapcore.Field{zap.String("s", "v"), zap.Int("n", 3), zap.Bool("b", true), zap.Duration("d", 1500*time.Millisecond)},
+			want:   map[string]interface{}{"s": "v", "n": int64(3), "b": true, "d": 1.5},
+		},
+		{
+			name:   "namespace",
+			fields: []zapcore.Field{zap.String("top", "1"), zap.Namespace("ns"), zap.String("in", "2")},
+			want:   map[string]interface{}{"top": "1", "ns": map[string]interface{}{"in": "2"}},
+		},
+		{
+			name:   "namespace from With",
+			with:   []zapcore.Field{zap.String("service", "api"), zap.Namespace("ctx"), zap.String("k", "v")},
+			fields: []zapcore.Field{zap.String("f", "1")},
+			want: map[string]interface{}{
+				"service": "api",
+				"ctx":     map[string]interface{}{"k": "v", "f": "1"},
+			},
+		},
+		{
+			name:   "namespace inside object",
+			fields: []zapcore.Field{zap.Object("obj", nested), zap.String("after", "3")},
+			want: map[string]interface{}{
+				"obj":   map[string]interface{}{"a": "1", "inner": map[string]interface{}{"b": "2"}},
+				"after": "3",
+			},
+		},
+		{
+			name:   "reflected",
+			fields: []zapcore.Field{zap.Any("r", map[string]interface{}{"count": 2, "ratio": 0.5, "list": []int{1}})},
+			want: map[string]interface{}{
+				"r": map[string]interface{}{"count": int64(2), "ratio": 0.5, "list": []interface{}{int64(1)}},
+			},
+		},
+		{
+			name:   "array",
+			fields: []zapcore.Field{zap.Strings("list", []string{"x", "y"})},
+			want:   map[string]interface{}{"list": []interface{}{"x", "y"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc := zapcore.Encoder(&bsonEncoder{})
+			if len(tt.with) > 0 {
+				enc = enc.Clone()
+				for _, field := range tt.with {
+					field.AddTo(enc)
+				}
+			}
+
+			got := encodeTestEntry(t, enc, tt.fields)
+			for _, key := range []string{"level", "ts", "logger", "msg"} {
+				delete(got, key)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("encoded %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBSONEncoderClone(t *testing.T) {
+	enc := &bsonEncoder{}
+	enc.AddString("shared", "1")
+	enc.OpenNamespace("ns")
+
+	clone := enc.Clone()
+	clone.AddString("only_clone", "2")
+
+	got := encodeTestEntry(t, enc, []zapcore.Field{zap.String("f", "3")})
+	want := map[string]interface{}{"f": "3"}
+	if ns, _ := got["ns"].(map[string]interface{}); !reflect.DeepEqual(ns, want) || got["shared"] != "1" {
+		t.Errorf("original encoder encoded %v, want shared and ns %v", got, want)
+	}
+
+	got = encodeTestEntry(t, clone, nil)
+	want = map[string]interface{}{"only_clone": "2"}
+	if ns, _ := got["ns"].(map[string]interface{}); !reflect.DeepEqual(ns, want) {
+		t.Errorf("clone encoded ns %v, want %v", got["ns"], want)
+	}
+}
+
+func encodeTestEntry(t *testing.T, enc zapcore.Encoder, fields []zapcore.Field) map[string]interface{} {
+	t.Helper()
+
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: testTime, LoggerName: "http.log.access", Message: "handled request"}
+	buf, err := enc.EncodeEntry(ent, fields)
+	if err != nil {
+		t.Fatalf("EncodeEntry: %v", err)
+	}
+	defer buf.Free()
+
+	if !isBSON(buf.Bytes()) {
+		t.Fatalf("EncodeEntry output is not a BSON document")
+	}
+
+	got, err := newTestWriter(&MongoLog{}).decodeEntry(buf.Bytes())
+	if err != nil {
+		t.Fatalf("decodeEntry: %v", err)
+	}
+
+	if got["level"] != "info" || got["logger"] != "http.log.access" || got["msg"] != "handled request" {
+		t.Errorf("entry header = %v %v %v", got["level"], got["logger"], got["msg"])
+	}
+	return got
+}
+
+func TestDecodeEntry(t *testing.T) {
+	raw, err := bson.Marshal(bson.M{"a": bson.M{"b": int32(1)}, "c": bson.A{"x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		entry []byte
+		want  map[string]interface{}
+	}{
+		{
+			name:  "bson",
+			entry: raw,
+			want:  map[string]interface{}{"a": map[string]interface{}{"b": int32(1)}, "c": []interface{}{"x"}},
+		},
+		{
+			name:  "json",
+			entry: []byte(`{"a":{"b":1},"c":["x"]}` + "\n"),
+			want:  map[string]interface{}{"a": map[string]interface{}{"b": float64(1)}, "c": []interface{}{"x"}},
+		},
+		{
+			name:  "plain text",
+			entry: []byte("2024/10/14 12:00:00 INFO started\n"),
+			want:  map[string]interface{}{"message": "2024/10/14 12:00:00 INFO started"},
+		},
+		{
+			name:  "plain text with a brace",
+			entry: []byte("3 requests} served\r\n"),
+			want:  map[string]interface{}{"message": "3 requests} served"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTestWriter(&MongoLog{}).decodeEntry(tt.entry)
+			if err != nil {
+				t.Fatalf("decodeEntry: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeEntry = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
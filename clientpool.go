@@ -0,0 +1,111 @@
+package mongo_log
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// clients shares mongo clients between writers with the same connection
+// settings, within a config and across reloads.
+var clients = caddy.NewUsagePool()
+
+type pooledClient struct {
+	*mongo.Client
+}
+
+func (c pooledClient) Destruct() error {
+	return c.Disconnect(context.Background())
+}
+
+// clientKey identifies the client clientOptions builds for uri, so it must
+// cover every field clientOptions reads.
+func (l *MongoLog) clientKey(uri string) string {
+	b, _ := json.Marshal(struct {
+		URI                    string
+		TLS                    *TLSConfig
+		MaxPoolSize            uint64
+		MinPoolSize            uint64
+		ConnectTimeout         caddy.Duration
+		ServerSelectionTimeout caddy.Duration
+		SocketTimeout          caddy.Duration
+		Compressors            []string
+		AppName                string
+		MonitorServers         bool
+		Username               string
+		Password               string
+		AuthMechanism          string
+		AuthSource             string
+		Kerberos               *Kerberos
+		CertFile               string
+		KeyFile                string
+		Compat                 string
+		RetryWrites            *bool
+		RetryReads             *bool
+		Certificates           string
+	}{
+		uri, l.TLS, l.MaxPoolSize, l.MinPoolSize, l.ConnectTimeout,
+		l.ServerSelectionTimeout, l.SocketTimeout, l.Compressors, l.AppName, l.MonitorServers,
+		l.Username, l.Password, l.AuthMechanism, l.AuthSource, l.Kerberos, l.CertFile, l.KeyFile, l.Compat,
+		l.RetryWrites, l.RetryReads, l.certificateDigest(),
+	})
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// certificateDigest hashes the contents of the certificate and key files
+// the client is built with, so certificates rotated in place get a new
+// client on reload.
+func (l *MongoLog) certificateDigest() string {
+	files := []string{l.CertFile, l.KeyFile}
+	if l.TLS != nil {
+		files = append(files, l.TLS.CAFile, l.TLS.CertFile, l.TLS.KeyFile)
+	}
+
+	h := sha256.New()
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			data = []byte(err.Error())
+		}
+		sum := sha256.Sum256(data)
+		h.Write(sum[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// acquireClient returns the shared client for uri, connecting a new one if
+// no writer holds it. Every successful call must be paired with
+// releaseClient on the returned key.
+func (l *MongoLog) acquireClient(ctx context.Context, uri string) (*mongo.Client, string, error) {
+	key := l.clientKey(uri)
+
+	val, _, err := clients.LoadOrNew(key, func() (caddy.Destructor, error) {
+		con, err := mongo.Connect(ctx, l.clientOptions(uri))
+		if err != nil {
+			return nil, err
+		}
+		return pooledClient{con}, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return val.(pooledClient).Client, key, nil
+}
+
+// releaseClient drops a reference taken by acquireClient, disconnecting the
+// client once no writer uses it.
+func releaseClient(key string) {
+	clients.Delete(key)
+}
@@ -0,0 +1,192 @@
+package mongo_log
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// codeNamespaceExists is returned by create when the collection already exists.
+const codeNamespaceExists = 48
+
+func isNamespaceExists(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == codeNamespaceExists
+}
+
+// createCollectionOptions returns the options a collection must be
+// created with, or nil if mongo can create it implicitly on first insert.
+// Only log collections are validated.
+func (l *MongoLog) createCollectionOptions(validate bool) *options.CreateCollectionOptions {
+	switch {
+	case l.TimeSeries != nil:
+		ts := options.TimeSeries().
+			SetTimeField(l.TimeSeries.TimeField).
+			SetMetaField(l.TimeSeries.MetaField)
+		if l.TimeSeries.Granularity != "" {
+			ts.SetGranularity(l.TimeSeries.Granularity)
+		}
+
+		opts := options.CreateCollection().SetTimeSeriesOptions(ts)
+		if l.Retention > 0 {
+			opts.SetExpireAfterSeconds(int64(time.Duration(l.Retention) / time.Second))
+		}
+
+		return opts
+
+	case l.Capped:
+		opts := options.CreateCollection().
+			SetCapped(true).
+			SetSizeInBytes(l.MaxSize)
+		if l.MaxDocs > 0 {
+			opts.SetMaxDocuments(l.MaxDocs)
+		}
+
+		return opts
+	}
+
+	if validate {
+		return options.CreateCollection()
+	}
+
+	return nil
+}
+
+// ensureCollection creates the named collection if it needs explicit
+// options and does not exist yet. With validate, it gets the $jsonSchema
+// validator of SchemaValidation.
+func (l *MongoLog) ensureCollection(ctx context.Context, db *mongo.Database, name string, validate bool) error {
+	validate = validate && l.SchemaValidation != ""
+
+	opts := l.createCollectionOptions(validate)
+	if opts == nil {
+		return nil
+	}
+
+	if validate {
+		opts.SetValidator(bson.M{"$jsonSchema": l.jsonSchema()}).
+			SetValidationLevel("moderate").
+			SetValidationAction(l.SchemaValidation)
+	}
+
+	err := db.CreateCollection(ctx, name, opts)
+	if err != nil && !isNamespaceExists(err) {
+		return err
+	}
+
+	if err != nil && validate {
+		return l.updateValidator(ctx, db, name)
+	}
+
+	return nil
+}
+
+// resolveNames expands global placeholders such as {system.hostname} or
+// {env.TENANT} in the database and collection names. Date layouts in the
+// collection name are not placeholders and are kept for rotation.
+func (l *MongoLog) resolveNames() {
+	repl := caddy.NewReplacer()
+
+	l.Database = repl.ReplaceKnown(l.Database, "")
+	l.Collection = repl.ReplaceKnown(l.Collection, "")
+	l.DeadLetter = repl.ReplaceKnown(l.DeadLetter, "")
+
+	for host, collection := range l.Routes {
+		l.Routes[host] = repl.ReplaceKnown(collection, "")
+	}
+
+	for level, collection := range l.LevelRoutes {
+		l.LevelRoutes[level] = repl.ReplaceKnown(collection, "")
+	}
+
+	for name, route := range l.LoggerRoutes {
+		l.LoggerRoutes[name] = LoggerRoute{
+			Database:   repl.ReplaceKnown(route.Database, ""),
+			Collection: repl.ReplaceKnown(route.Collection, ""),
+		}
+	}
+}
+
+// target identifies the collection a document is written to. Auxiliary
+// targets, such as dead letters or events, do not hold log entries and
+// are not validated against the log schema.
+type target struct {
+	database   string
+	collection string
+	auxiliary  bool
+}
+
+// openCollection creates the target collection if needed and returns its
+// handle on client.
+func (l *MongoLog) openCollection(ctx context.Context, client *mongo.Client, t target) (*mongo.Collection, error) {
+	db := client.Database(t.database)
+	if err := l.ensureCollection(ctx, db, t.collection, !t.auxiliary); err != nil {
+		return nil, err
+	}
+
+	return db.Collection(t.collection, l.collectionOptions()), nil
+}
+
+// collectionFor returns the handle for t, creating the collection and its
+// indexes the first time t is used on the current client.
+func (mWrite *mongoWriter) collectionFor(t target) (*mongo.Collection, error) {
+	mWrite.connMu.RLock()
+	client := mWrite.client
+	collection := mWrite.collections[t]
+	mWrite.connMu.RUnlock()
+
+	if collection != nil {
+		return collection, nil
+	}
+
+	if client == nil {
+		return nil, errNotConnected
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	collection, err := mWrite.config.openCollection(ctx, client, t)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mWrite.config.ensureIndexes(ctx, collection); err != nil {
+		mWrite.logger.Error("Could not create indexes on log collection", zap.String("collection", t.collection), zap.Error(err))
+	}
+
+	if len(mWrite.config.ShardKey) > 0 {
+		if err := mWrite.config.ensureSharded(ctx, collection); err != nil {
+			mWrite.logger.Error("Could not shard log collection", zap.String("collection", t.collection), zap.Error(err))
+		}
+	}
+
+	mWrite.connMu.Lock()
+	if mWrite.client == client {
+		mWrite.collections[t] = collection
+	}
+	mWrite.connMu.Unlock()
+
+	return collection, nil
+}
+
+// readCollection returns a handle to query t with. Unlike collectionFor it
+// neither creates the collection nor caches the handle, so reads of
+// arbitrary names leave no trace on the server or the writer.
+func (mWrite *mongoWriter) readCollection(t target) (*mongo.Collection, error) {
+	mWrite.connMu.RLock()
+	client := mWrite.client
+	mWrite.connMu.RUnlock()
+
+	if client == nil {
+		return nil, errNotConnected
+	}
+
+	return client.Database(t.database).Collection(t.collection, mWrite.config.collectionOptions()), nil
+}
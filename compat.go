@@ -0,0 +1,54 @@
+package mongo_log
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const (
+	compatDocumentDB = "documentdb"
+	compatCosmosDB   = "cosmosdb"
+)
+
+// validateCompat rejects features the compat target does not implement.
+func (l *MongoLog) validateCompat() error {
+	switch l.Compat {
+	case "":
+		return nil
+	case compatDocumentDB, compatCosmosDB:
+	default:
+		return fmt.Errorf("unsupported compat %q", l.Compat)
+	}
+
+	switch {
+	case l.TimeSeries != nil:
+		return fmt.Errorf("timeseries collections are not supported with compat %s", l.Compat)
+	case l.Capped && l.Compat == compatDocumentDB:
+		return fmt.Errorf("capped collections are not supported with compat %s", l.Compat)
+	case l.Encryption != nil:
+		return fmt.Errorf("field encryption is not supported with compat %s", l.Compat)
+	case l.WildcardIndex && l.Compat == compatDocumentDB:
+		return fmt.Errorf("wildcard_index is not supported with compat %s", l.Compat)
+	case l.Rollup != nil:
+		return fmt.Errorf("rollup is not supported with compat %s", l.Compat)
+	case l.SchemaValidation != "" && l.Compat == compatCosmosDB:
+		return fmt.Errorf("schema_validation is not supported with compat %s", l.Compat)
+	case len(l.Expire) > 0 && l.Compat == compatCosmosDB:
+		return fmt.Errorf("expire is not supported with compat %s", l.Compat)
+	case l.Retention > 0 && l.Compat == compatCosmosDB:
+		// cosmos only expires documents through a TTL index on _ts
+		return fmt.Errorf("retention is not supported with compat %s, set a TTL on the collection instead", l.Compat)
+	}
+
+	return nil
+}
+
+// compatWriteConcern is used when no write_concern is configured: neither
+// target acknowledges beyond the primary.
+func (l *MongoLog) compatWriteConcern() *writeconcern.WriteConcern {
+	if l.Compat == "" {
+		return nil
+	}
+	return writeconcern.W1()
+}
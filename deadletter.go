@@ -0,0 +1,78 @@
+package mongo_log
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxDeadLetterRaw caps the raw field of a dead letter so a document
+	// that was rejected for its size still fits.
+	maxDeadLetterRaw = 1 << 20
+
+	// deadLetterSuffix names the dead letter collection when the main one
+	// is validated.
+	deadLetterSuffix = "_dead_letter"
+)
+
+// deadLetterTarget is the DeadLetter collection if one is set and the
+// default one otherwise.
+func (mWrite *mongoWriter) deadLetterTarget(now time.Time) target {
+	t := mWrite.config.target(nil, now)
+	if mWrite.config.DeadLetter != "" {
+		t.collection = expandCollectionName(mWrite.config.DeadLetter, now)
+	}
+	t.auxiliary = true
+	return t
+}
+
+// deadLetter stores a log line that is not valid JSON as a raw string.
+func (mWrite *mongoWriter) deadLetter(p []byte, err error) {
+	mWrite.addDeadLetter(mWrite.deadLetterTarget(time.Now()), string(p), err)
+}
+
+// rejected dead-letters a document the server refused to insert into t,
+// as extended JSON. Documents refused by the dead letter collection itself
+// are dropped.
+func (mWrite *mongoWriter) rejected(t target, doc interface{}, err error) {
+	dl := mWrite.deadLetterTarget(time.Now())
+	if t.database == dl.database && t.collection == dl.collection {
+		mWrite.logger.Error("Dropping log document rejected by dead letter collection", zap.Error(err))
+		mWrite.drop(dropRejected)
+		return
+	}
+
+	raw, marshalErr := bson.MarshalExtJSON(doc, false, false)
+	if marshalErr != nil {
+		mWrite.logger.Error("Dropping rejected log document", zap.Error(err), zap.NamedError("marshal", marshalErr))
+		mWrite.drop(dropRejected)
+		return
+	}
+
+	mWrite.logger.Warn("Log document rejected, moving it to the dead letter collection", zap.String("collection", t.collection), zap.Error(err))
+	mWrite.addDeadLetter(dl, string(raw), err)
+}
+
+func (mWrite *mongoWriter) addDeadLetter(t target, raw string, err error) {
+	tagsField := "tags"
+	if ts := mWrite.config.TimeSeries; ts != nil {
+		tagsField = ts.MetaField
+	}
+
+	doc := bson.M{
+		tagsField:                 mWrite.resolveTags(nil),
+		"raw":                     raw,
+		"error":                   err.Error(),
+		mWrite.config.dateField(): primitive.NewDateTimeFromTime(time.Now()),
+	}
+
+	if len(raw) > maxDeadLetterRaw {
+		doc["raw"] = raw[:maxDeadLetterRaw]
+		doc["raw_truncated"] = true
+	}
+
+	mWrite.add(t, doc)
+}
@@ -0,0 +1,45 @@
+package mongo_log
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// accessLogEntry is a typical Caddy access log line.
+var accessLogEntry = []byte(`{"level":"info","ts":1728878307.123456,"logger":"http.log.access.log0","msg":"handled request","request":{"remote_ip":"203.0.113.7","remote_port":"51234","client_ip":"203.0.113.7","proto":"HTTP/2.0","method":"GET","host":"example.com","uri":"/api/v1/items?page=2","headers":{"Accept":["application/json"],"Accept-Encoding":["gzip, deflate, br"],"User-Agent":["Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0 Safari/537.36"],"Cookie":["REDACTED"]},"tls":{"resumed":false,"version":772,"cipher_suite":4865,"proto":"h2","server_name":"example.com"}},"bytes_read":0,"user_id":"","duration":0.004512345,"size":5123,"status":200,"resp_headers":{"Content-Type":["application/json"],"Server":["Caddy"],"Content-Encoding":["gzip"],"Vary":["Accept-Encoding"]}}`)
+
+// The decoders process could use on an entry. encoding/json into a map is
+// what it does; the extended JSON decoders are the alternatives measured
+// against it.
+
+func BenchmarkDecodeJSON(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f := map[string]interface{}{}
+		if err := json.Unmarshal(accessLogEntry, &f); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeExtJSONMap(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f := bson.M{}
+		if err := bson.UnmarshalExtJSON(accessLogEntry, false, &f); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeExtJSONRaw(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var raw bson.Raw
+		if err := bson.UnmarshalExtJSON(accessLogEntry, false, &raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package mongo_log
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(MongoLogDefaults{})
+	httpcaddyfile.RegisterGlobalOption("mongo_log_defaults", parseDefaultsOption)
+}
+
+// MongoLogDefaults is an app holding connection settings inherited by every
+// mongo_log writer that leaves them unset. Its fields mirror the MongoLog
+// fields of the same name.
+type MongoLogDefaults struct {
+	MongoUri      string         `json:"mongoUri,omitempty"`
+	FallbackUris  []string       `json:"fallback_uris,omitempty"`
+	FailoverAfter caddy.Duration `json:"failover_after,omitempty"`
+	MongoUriFile  string         `json:"mongo_uri_file,omitempty"`
+	Database      string         `json:"database,omitempty"`
+
+	Username      string    `json:"username,omitempty"`
+	UsernameFile  string    `json:"username_file,omitempty"`
+	Password      string    `json:"password,omitempty"`
+	PasswordFile  string    `json:"password_file,omitempty"`
+	AuthMechanism string    `json:"auth_mechanism,omitempty"`
+	AuthSource    string    `json:"auth_source,omitempty"`
+	Kerberos      *Kerberos `json:"kerberos,omitempty"`
+	CertFile      string    `json:"cert_file,omitempty"`
+	KeyFile       string    `json:"key_file,omitempty"`
+
+	TLS                    *TLSConfig     `json:"tls,omitempty"`
+	MaxPoolSize            uint64         `json:"max_pool_size,omitempty"`
+	MinPoolSize            uint64         `json:"min_pool_size,omitempty"`
+	ConnectTimeout         caddy.Duration `json:"connect_timeout,omitempty"`
+	ServerSelectionTimeout caddy.Duration `json:"server_selection_timeout,omitempty"`
+	SocketTimeout          caddy.Duration `json:"socket_timeout,omitempty"`
+	Compressors            []string       `json:"compressors,omitempty"`
+	Compat                 string         `json:"compat,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (MongoLogDefaults) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "mongo_log",
+		New: func() caddy.Module { return new(MongoLogDefaults) },
+	}
+}
+
+func (*MongoLogDefaults) Start() error { return nil }
+func (*MongoLogDefaults) Stop() error  { return nil }
+
+// inherit sets the fields of l that d shares and l leaves unset.
+func (d *MongoLogDefaults) inherit(l *MongoLog) {
+	dv, lv := reflect.ValueOf(d).Elem(), reflect.ValueOf(l).Elem()
+	for i := 0; i < dv.NumField(); i++ {
+		if f := lv.FieldByName(dv.Type().Field(i).Name); f.IsZero() {
+			f.Set(dv.Field(i))
+		}
+	}
+}
+
+// inheritDefaults applies the mongo_log app, if configured, to l.
+func (l *MongoLog) inheritDefaults(ctx caddy.Context) error {
+	app, err := ctx.AppIfConfigured("mongo_log")
+	if errors.Is(err, caddy.ErrNotConfigured) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	app.(*MongoLogDefaults).inherit(l)
+	return nil
+}
+
+// parseDefaultsOption parses the mongo_log_defaults global option, which
+// takes the connection subdirectives of an output mongo_log block:
+//
+//	mongo_log_defaults {
+//		mongoUri mongodb://db1:27017
+//		database logs
+//		tls {
+//			ca_file /etc/ssl/mongo-ca.pem
+//		}
+//	}
+func parseDefaultsOption(d *caddyfile.Dispenser, _ any) (any, error) {
+	l := new(MongoLog)
+	if err := l.UnmarshalCaddyfile(d); err != nil {
+		return nil, err
+	}
+
+	defaults := new(MongoLogDefaults)
+	dv, lv := reflect.ValueOf(defaults).Elem(), reflect.ValueOf(l).Elem()
+	for i := 0; i < dv.NumField(); i++ {
+		f := lv.FieldByName(dv.Type().Field(i).Name)
+		dv.Field(i).Set(f)
+		f.SetZero()
+	}
+
+	if !reflect.DeepEqual(l, new(MongoLog)) {
+		return nil, fmt.Errorf("mongo_log_defaults only accepts connection settings")
+	}
+
+	return httpcaddyfile.App{
+		Name:  "mongo_log",
+		Value: caddyconfig.JSON(defaults, nil),
+	}, nil
+}
+
+// Interface guards.
+var (
+	_ caddy.App = (*MongoLogDefaults)(nil)
+)
@@ -0,0 +1,103 @@
+package mongo_log
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+const ecsVersion = "8.11.0"
+
+// ecsFields maps Caddy access log paths to their Elastic Common Schema
+// names.
+var ecsFields = []struct {
+	from string
+	to   string
+}{
+	{"request.method", "http.request.method"},
+	{"request.host", "url.domain"},
+	{"request.uri", "url.original"},
+	{"request.remote_ip", "source.ip"},
+	{"request.remote_port", "source.port"},
+	{"request.client_ip", "client.ip"},
+	{"request.tls.server_name", "tls.client.server_name"},
+	{"request.tls.proto", "tls.next_protocol"},
+	{"bytes_read", "http.request.body.bytes"},
+	{"status", "http.response.status_code"},
+	{"size", "http.response.body.bytes"},
+	{"user_id", "user.name"},
+	{"level", "log.level"},
+	{"logger", "log.logger"},
+	{"msg", "message"},
+}
+
+// ecsHeaders maps single-valued headers to ECS fields.
+var ecsHeaders = []struct {
+	from string
+	to   string
+}{
+	{"request.headers.User-Agent", "user_agent.original"},
+	{"request.headers.Referer", "http.request.referrer"},
+	{"resp_headers.Content-Type", "http.response.mime_type"},
+}
+
+// toECS rewrites a Caddy log entry into ECS field names. Fields without an
+// ECS equivalent are kept under "caddy".
+func toECS(entry map[string]interface{}) map[string]interface{} {
+	ecs := map[string]interface{}{}
+	setPath(ecs, []string{"ecs", "version"}, ecsVersion)
+
+	for _, field := range ecsFields {
+		if v, ok := deletePath(entry, splitPath(field.from)); ok {
+			setPath(ecs, splitPath(field.to), v)
+		}
+	}
+
+	for _, header := range ecsHeaders {
+		if v, ok := lookup(entry, splitPath(header.from)...); ok {
+			if values, ok := v.([]interface{}); ok && len(values) > 0 {
+				setPath(ecs, splitPath(header.to), values[0])
+			}
+		}
+	}
+
+	if proto, ok := deletePath(entry, []string{"request", "proto"}); ok {
+		if s, ok := proto.(string); ok {
+			setPath(ecs, []string{"http", "version"}, strings.TrimPrefix(s, "HTTP/"))
+		}
+	}
+
+	if uri, ok := lookup(ecs, "url", "original"); ok {
+		if s, ok := uri.(string); ok {
+			if u, err := url.ParseRequestURI(s); err == nil {
+				setPath(ecs, []string{"url", "path"}, u.Path)
+				if u.RawQuery != "" {
+					setPath(ecs, []string{"url", "query"}, u.RawQuery)
+				}
+			}
+		}
+	}
+
+	// caddy logs durations in seconds, ECS wants nanoseconds
+	if duration, ok := deletePath(entry, []string{"duration"}); ok {
+		if seconds, ok := toFloat(duration); ok {
+			setPath(ecs, []string{"event", "duration"}, int64(seconds*float64(time.Second)))
+		}
+	}
+
+	if ts, ok := deletePath(entry, []string{"ts"}); ok {
+		if t, ok := parseTimestamp(ts); ok {
+			ecs["@timestamp"] = t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	if request, ok := entry["request"].(map[string]interface{}); ok && len(request) == 0 {
+		delete(entry, "request")
+	}
+
+	if len(entry) > 0 {
+		ecs["caddy"] = entry
+	}
+
+	return ecs
+}
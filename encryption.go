@@ -0,0 +1,289 @@
+package mongo_log
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	encryptDeterministic = "deterministic"
+	encryptRandom        = "random"
+
+	localMasterKeySize = 96
+)
+
+// Encryption explicitly encrypts selected fields with client-side field
+// level encryption before documents leave the process. It requires a
+// build with the "cse" tag and libmongocrypt.
+type Encryption struct {
+	// Key vault namespace, e.g. "encryption.__keyVault".
+	KeyVault string `json:"key_vault,omitempty"`
+
+	// Data key to encrypt with. It is created on first connect if missing.
+	KeyAltName string `json:"key_alt_name,omitempty"`
+
+	// One of local, aws, gcp or azure.
+	Provider string `json:"kms_provider,omitempty"`
+
+	// Provider credentials, e.g. accessKeyId and secretAccessKey for aws.
+	// For local, key_file points at the 96 byte master key, raw or base64.
+	// Empty aws credentials are resolved from the environment.
+	KMS map[string]string `json:"kms,omitempty"`
+
+	// Provider specific master key, e.g. region and key for aws.
+	MasterKey map[string]string `json:"master_key,omitempty"`
+
+	// "deterministic" (default) keeps equality queries possible, "random"
+	// is stronger.
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Entry paths to encrypt, e.g. request.headers.Authorization. They
+	// are matched on the entry after transforms but before it is wrapped
+	// in the envelope, template or time-series layout, so without a
+	// metadata. prefix.
+	Fields []string `json:"fields,omitempty"`
+
+	kmsProviders map[string]map[string]interface{}
+}
+
+func parseEncryption(d *caddyfile.Dispenser) (*Encryption, error) {
+	e := &Encryption{}
+
+	parseMap := func() map[string]string {
+		m := map[string]string{}
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			key := d.Val()
+			if d.NextArg() {
+				m[key] = d.Val()
+			}
+		}
+		return m
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "key_vault":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			e.KeyVault = d.Val()
+
+		case "key_alt_name":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			e.KeyAltName = d.Val()
+
+		case "kms_provider":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			e.Provider = d.Val()
+
+		case "kms":
+			e.KMS = parseMap()
+
+		case "master_key":
+			e.MasterKey = parseMap()
+
+		case "algorithm":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			e.Algorithm = d.Val()
+
+		case "fields":
+			e.Fields = append(e.Fields, d.RemainingArgs()...)
+
+		default:
+			return nil, d.Errf("unrecognized encryption option %q", d.Val())
+		}
+	}
+
+	return e, nil
+}
+
+// validateEncryptedFields rejects fields given as paths of the stored
+// document, which never match an entry and would leave the value in
+// plaintext.
+func (l *MongoLog) validateEncryptedFields() error {
+	for _, field := range l.Encryption.Fields {
+		root, _, _ := strings.Cut(field, ".")
+
+		switch {
+		case root == "metadata" && l.Template == nil:
+			return fmt.Errorf("encryption field %q is a stored document path, use the entry path %q", field, strings.TrimPrefix(field, "metadata."))
+		case root == "tags", root == l.dateField(), l.TimeSeries != nil && root == l.TimeSeries.MetaField:
+			return fmt.Errorf("encryption field %q is part of the envelope, not an entry path", field)
+		}
+
+		if value, ok := l.Template[field]; ok && value != "{entry."+field+"}" {
+			return fmt.Errorf("encryption field %q is a template output, use the entry path it is built from", field)
+		}
+	}
+
+	return nil
+}
+
+func (e *Encryption) validate() error {
+	if db, coll, ok := strings.Cut(e.KeyVault, "."); !ok || db == "" || coll == "" {
+		return fmt.Errorf("encryption key_vault must be a database.collection namespace")
+	}
+
+	if e.KeyAltName == "" {
+		return fmt.Errorf("encryption requires key_alt_name")
+	}
+
+	switch e.Provider {
+	case "local", "aws", "gcp", "azure":
+	default:
+		return fmt.Errorf("unsupported encryption kms_provider %q", e.Provider)
+	}
+
+	switch e.Algorithm {
+	case "", encryptDeterministic, encryptRandom:
+	default:
+		return fmt.Errorf("invalid encryption algorithm %q", e.Algorithm)
+	}
+
+	if len(e.Fields) == 0 {
+		return fmt.Errorf("encryption requires at least one field")
+	}
+
+	return nil
+}
+
+// provision loads the KMS provider credentials.
+func (e *Encryption) provision() error {
+	creds := map[string]interface{}{}
+	for k, v := range e.KMS {
+		creds[k] = v
+	}
+
+	if e.Provider == "local" {
+		keyFile, ok := e.KMS["key_file"]
+		if !ok {
+			return fmt.Errorf("local encryption requires kms key_file")
+		}
+
+		key, err := readLocalMasterKey(keyFile)
+		if err != nil {
+			return err
+		}
+		creds = map[string]interface{}{"key": key}
+	}
+
+	e.kmsProviders = map[string]map[string]interface{}{e.Provider: creds}
+	return nil
+}
+
+func readLocalMasterKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading encryption key_file: %v", err)
+	}
+
+	if len(data) == localMasterKeySize {
+		return data, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != localMasterKeySize {
+		return nil, fmt.Errorf("encryption key_file must hold a %d byte key", localMasterKeySize)
+	}
+	return key, nil
+}
+
+func (e *Encryption) algorithm() string {
+	if e.Algorithm == encryptRandom {
+		return "AEAD_AES_256_CBC_HMAC_SHA_512-Random"
+	}
+	return "AEAD_AES_256_CBC_HMAC_SHA_512-Deterministic"
+}
+
+// open creates the client encryption handle on client and makes sure the
+// data key exists.
+func (e *Encryption) open(ctx context.Context, client *mongo.Client) (*mongo.ClientEncryption, error) {
+	ce, err := mongo.NewClientEncryption(client, options.ClientEncryption().
+		SetKeyVaultNamespace(e.KeyVault).
+		SetKmsProviders(e.kmsProviders))
+	if err != nil {
+		return nil, err
+	}
+
+	err = ce.GetKeyByAltName(ctx, e.KeyAltName).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		opts := options.DataKey().SetKeyAltNames([]string{e.KeyAltName})
+		if len(e.MasterKey) > 0 {
+			opts.SetMasterKey(e.MasterKey)
+		}
+
+		_, err = ce.CreateDataKey(ctx, e.Provider, opts)
+	}
+
+	if err != nil {
+		ce.Close(ctx)
+		return nil, err
+	}
+
+	return ce, nil
+}
+
+// encryptFields replaces the configured fields with their ciphertext. A
+// field that cannot be encrypted is dropped rather than stored in clear.
+func (mWrite *mongoWriter) encryptFields(entry map[string]interface{}) {
+	e := mWrite.config.Encryption
+
+	mWrite.connMu.RLock()
+	ce := mWrite.encryption
+	mWrite.connMu.RUnlock()
+
+	for _, field := range e.Fields {
+		path := splitPath(field)
+
+		v, ok := lookup(entry, path...)
+		if !ok {
+			continue
+		}
+
+		err := errNotConnected
+		if ce != nil {
+			var encrypted interface{}
+			if encrypted, err = encryptValue(ce, e, v); err == nil {
+				setPath(entry, path, encrypted)
+				continue
+			}
+		}
+
+		deletePath(entry, path)
+		mWrite.logger.Error("Could not encrypt log field, dropping it", zap.String("field", field), zap.Error(err))
+	}
+}
+
+func encryptValue(ce *mongo.ClientEncryption, e *Encryption, v interface{}) (interface{}, error) {
+	t, data, err := bson.MarshalValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	return ce.Encrypt(ctx, bson.RawValue{Type: t, Value: data}, options.Encrypt().
+		SetAlgorithm(e.algorithm()).
+		SetKeyAltName(e.KeyAltName))
+}
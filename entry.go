@@ -0,0 +1,64 @@
+package mongo_log
+
+import (
+	"strings"
+)
+
+// lookup walks a decoded log entry along path, e.g. "request", "host".
+func lookup(entry map[string]interface{}, path ...string) (interface{}, bool) {
+	var v interface{} = entry
+	for _, key := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+func lookupString(entry map[string]interface{}, path ...string) string {
+	v, _ := lookup(entry, path...)
+	s, _ := v.(string)
+	return s
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// setPath stores v at path, creating intermediate sub-documents as needed.
+func setPath(entry map[string]interface{}, path []string, v interface{}) {
+	m := entry
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[key] = next
+		}
+		m = next
+	}
+
+	m[path[len(path)-1]] = v
+}
+
+// deletePath removes the value at path, reporting whether it was present.
+func deletePath(entry map[string]interface{}, path []string) (interface{}, bool) {
+	m := entry
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		m = next
+	}
+
+	key := path[len(path)-1]
+	v, ok := m[key]
+	delete(m, key)
+	return v, ok
+}
@@ -0,0 +1,128 @@
+package mongo_log
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(MongoLogEvents{})
+}
+
+const defaultEventsCollection = "caddy_events"
+
+// MongoLogEvents stores the Caddy events it is subscribed to, such as
+// cert_obtained or started, through a mongo_log writer, giving an
+// operational trail next to the access logs:
+//
+//	events {
+//	    on * mongo_log {
+//	        writer logs.access
+//	        collection caddy_events
+//	    }
+//	}
+type MongoLogEvents struct {
+	// The writer to store events with, named by database.collection. May
+	// be empty when only one writer is open.
+	Writer string `json:"writer,omitempty"`
+	// Collection in the writer's database, caddy_events by default.
+	Collection string `json:"collection,omitempty"`
+
+	logger *zap.Logger
+}
+
+// CaddyModule implements caddy.Module.
+func (MongoLogEvents) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "events.handlers.mongo_log",
+		New: func() caddy.Module { return new(MongoLogEvents) },
+	}
+}
+
+func (m *MongoLogEvents) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+
+	if m.Collection == "" {
+		m.Collection = defaultEventsCollection
+	}
+
+	return nil
+}
+
+// Handle queues a document for the event. Events are never aborted: a
+// missing writer only drops the document.
+func (m *MongoLogEvents) Handle(_ context.Context, e caddyevents.Event) error {
+	ce := e.CloudEvent()
+
+	doc := bson.M{
+		"id":     ce.ID,
+		"event":  ce.Type,
+		"origin": ce.Source,
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(ce.Data, &data); err == nil && len(data) > 0 {
+		doc["data"] = normalizeNumbers(data)
+	}
+
+	m.store(ce.Time, doc)
+	return nil
+}
+
+// store adds the date to doc and batches it on the writer, in its
+// database and m.Collection.
+func (m *MongoLogEvents) store(ts time.Time, doc bson.M) {
+	w, ok := lookupWriter(m.Writer)
+	if !ok {
+		m.logger.Warn("No mongo_log writer to store event in", zap.String("writer", m.Writer), zap.Strings("open", writerNames()))
+		return
+	}
+
+	doc[w.config.dateField()] = primitive.NewDateTimeFromTime(ts)
+	w.addDocument(target{database: w.config.Database, collection: m.Collection, auxiliary: true}, doc)
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens, with the
+// dispenser on its name.
+func (m *MongoLogEvents) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	if d.NextArg() {
+		m.Writer = d.Val()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "writer":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			m.Writer = d.Val()
+
+		case "collection":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			m.Collection = d.Val()
+
+		default:
+			return d.Errf("unrecognized mongo_log events option %q", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// Interface guards.
+var (
+	_ caddy.Provisioner     = (*MongoLogEvents)(nil)
+	_ caddyevents.Handler   = (*MongoLogEvents)(nil)
+	_ caddyfile.Unmarshaler = (*MongoLogEvents)(nil)
+)
@@ -0,0 +1,58 @@
+package mongo_log
+
+import (
+	"regexp"
+	"strings"
+)
+
+// compileGlobs turns patterns, in which * matches any run of characters,
+// into regexps anchored at both ends, allowing suffix after the match.
+func compileGlobs(patterns []string, suffix string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		expr := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+		res = append(res, regexp.MustCompile("^"+expr+suffix+"$"))
+	}
+	return res
+}
+
+// compileLoggerGlobs compiles logger name patterns, which like Caddy's
+// log include and exclude also match child loggers.
+func compileLoggerGlobs(patterns []string) []*regexp.Regexp {
+	return compileGlobs(patterns, `(\..*)?`)
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded reports whether an entry is filtered out by its logger name or
+// request path.
+func (l *MongoLog) excluded(entry map[string]interface{}) bool {
+	if len(l.includeLoggers) > 0 || len(l.excludeLoggers) > 0 {
+		logger := lookupString(entry, "logger")
+		if len(l.includeLoggers) > 0 && !matchAny(l.includeLoggers, logger) {
+			return true
+		}
+		if matchAny(l.excludeLoggers, logger) {
+			return true
+		}
+	}
+
+	if len(l.exclude) == 0 {
+		return false
+	}
+
+	uri := lookupString(entry, "request", "uri")
+	if uri == "" {
+		return false
+	}
+
+	path, _, _ := strings.Cut(uri, "?")
+	return matchAny(l.exclude, path)
+}
@@ -0,0 +1,100 @@
+package mongo_log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	expireAtField  = "expireAt"
+	expireFallback = "default"
+)
+
+// parseExpire parses the expire block, with the dispenser on "expire":
+//
+//	expire {
+//	    5xx     90d
+//	    404     1d
+//	    error   30d
+//	    default 7d
+//	}
+func parseExpire(d *caddyfile.Dispenser, expire map[string]caddy.Duration) error {
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		key := strings.ToLower(d.Val())
+
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+
+		dur, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return d.Errf("invalid expire duration %q: %v", d.Val(), err)
+		}
+		expire[key] = caddy.Duration(dur)
+
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	return nil
+}
+
+func (l *MongoLog) validateExpire() error {
+	for key, dur := range l.Expire {
+		_, levelErr := zapcore.ParseLevel(key)
+		valid := key == expireFallback || levelErr == nil ||
+			(len(key) == 3 && key[0] >= '1' && key[0] <= '5' &&
+				(key[1:] == "xx" || (isDigit(key[1]) && isDigit(key[2]))))
+		if !valid {
+			return fmt.Errorf("invalid expire rule %q, want a status code, class, level or default", key)
+		}
+
+		if time.Duration(dur) < time.Second {
+			return fmt.Errorf("expire %s must be at least one second", key)
+		}
+	}
+
+	switch {
+	case len(l.Expire) == 0:
+	case l.TimeSeries != nil:
+		return fmt.Errorf("expire is not supported on timeseries collections, use retention")
+	case l.Capped:
+		return fmt.Errorf("capped collections cannot have expire rules")
+	}
+
+	return nil
+}
+
+// expireAt computes when an entry's document is deleted from the first
+// expire rule matching its exact status, status class, level, or the
+// default rule. It returns false when no rule matches.
+func (l *MongoLog) expireAt(entry map[string]interface{}, now time.Time) (time.Time, bool) {
+	if len(l.Expire) == 0 {
+		return time.Time{}, false
+	}
+
+	var keys []string
+	if status, ok := toFloat(entry["status"]); ok {
+		code := strconv.Itoa(int(status))
+		keys = append(keys, code, code[:1]+"xx")
+	}
+	if level, ok := entry["level"].(string); ok {
+		keys = append(keys, strings.ToLower(level))
+	}
+	keys = append(keys, expireFallback)
+
+	for _, key := range keys {
+		if dur, ok := l.Expire[key]; ok {
+			return now.Add(time.Duration(dur)), true
+		}
+	}
+
+	return time.Time{}, false
+}
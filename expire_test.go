@@ -0,0 +1,48 @@
+package mongo_log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestExpireAt(t *testing.T) {
+	rules := map[string]caddy.Duration{
+		"404":          caddy.Duration(time.Hour),
+		"4xx":          caddy.Duration(2 * time.Hour),
+		"error":        caddy.Duration(3 * time.Hour),
+		expireFallback: caddy.Duration(4 * time.Hour),
+	}
+
+	tests := []struct {
+		name  string
+		rules map[string]caddy.Duration
+		entry map[string]interface{}
+		want  time.Duration
+		ok    bool
+	}{
+		{name: "exact status", rules: rules, entry: map[string]interface{}{"status": float64(404), "level": "error"}, want: time.Hour, ok: true},
+		{name: "status class", rules: rules, entry: map[string]interface{}{"status": float64(403)}, want: 2 * time.Hour, ok: true},
+		{name: "integer status", rules: rules, entry: map[string]interface{}{"status": int64(418)}, want: 2 * time.Hour, ok: true},
+		{name: "level", rules: rules, entry: map[string]interface{}{"status": float64(200), "level": "ERROR"}, want: 3 * time.Hour, ok: true},
+		{name: "default", rules: rules, entry: map[string]interface{}{"status": float64(200), "level": "info"}, want: 4 * time.Hour, ok: true},
+		{name: "no status or level", rules: rules, entry: map[string]interface{}{}, want: 4 * time.Hour, ok: true},
+		{name: "no matching rule", rules: map[string]caddy.Duration{"5xx": caddy.Duration(time.Hour)}, entry: map[string]interface{}{"status": float64(200)}},
+		{name: "no rules", entry: map[string]interface{}{"status": float64(500)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &MongoLog{Expire: tt.rules}
+
+			got, ok := l.expireAt(tt.entry, testTime)
+			if ok != tt.ok {
+				t.Fatalf("expireAt ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !got.Equal(testTime.Add(tt.want)) {
+				t.Errorf("expireAt = %v, want %v", got, testTime.Add(tt.want))
+			}
+		})
+	}
+}
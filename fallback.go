@@ -0,0 +1,129 @@
+package mongo_log
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const fallbackTimeout = 10 * time.Second
+
+// fallbackSink receives batches mongo did not accept, for an external
+// consumer to replay.
+type fallbackSink interface {
+	publish(ctx context.Context, t target, docs []interface{}) error
+	close() error
+}
+
+// validateFallback checks a fallback URL: kafka://broker[,broker]/topic or
+// nats://[user:pass@]host[:port]/subject.
+func validateFallback(fallback string) error {
+	u, err := url.Parse(fallback)
+	if err != nil {
+		return fmt.Errorf("invalid fallback %q: %v", fallback, err)
+	}
+
+	switch u.Scheme {
+	case "kafka", "nats":
+	default:
+		return fmt.Errorf("invalid fallback %q, want a kafka:// or nats:// URL", fallback)
+	}
+
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return fmt.Errorf("fallback %q needs a host and a topic or subject", fallback)
+	}
+	return nil
+}
+
+func openFallback(fallback string) (fallbackSink, error) {
+	u, err := url.Parse(fallback)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.Trim(u.Path, "/")
+
+	if u.Scheme == "kafka" {
+		return kafkaSink{writer: &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(u.Host, ",")...),
+			Topic:        name,
+			Balancer:     &kafka.LeastBytes{},
+			RequiredAcks: kafka.RequireAll,
+		}}, nil
+	}
+
+	addr := *u
+	addr.Path = ""
+	conn, err := nats.Connect(addr.String(), nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return natsSink{conn: conn, subject: name}, nil
+}
+
+// fallbackMessage encodes one document as relaxed extended JSON.
+func fallbackMessage(doc interface{}) ([]byte, error) {
+	return bson.MarshalExtJSON(doc, false, false)
+}
+
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+func (s kafkaSink) publish(ctx context.Context, t target, docs []interface{}) error {
+	msgs := make([]kafka.Message, 0, len(docs))
+	for _, doc := range docs {
+		data, err := fallbackMessage(doc)
+		if err != nil {
+			return err
+		}
+
+		msgs = append(msgs, kafka.Message{
+			Value: data,
+			Headers: []kafka.Header{
+				{Key: "database", Value: []byte(t.database)},
+				{Key: "collection", Value: []byte(t.collection)},
+			},
+		})
+	}
+
+	return s.writer.WriteMessages(ctx, msgs...)
+}
+
+func (s kafkaSink) close() error {
+	return s.writer.Close()
+}
+
+type natsSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func (s natsSink) publish(ctx context.Context, t target, docs []interface{}) error {
+	for _, doc := range docs {
+		data, err := fallbackMessage(doc)
+		if err != nil {
+			return err
+		}
+
+		msg := nats.NewMsg(s.subject)
+		msg.Data = data
+		msg.Header.Set("database", t.database)
+		msg.Header.Set("collection", t.collection)
+
+		if err := s.conn.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+
+	return s.conn.FlushWithContext(ctx)
+}
+
+func (s natsSink) close() error {
+	return s.conn.Drain()
+}
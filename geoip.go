@@ -0,0 +1,137 @@
+package mongo_log
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// GeoIP adds the country, city and autonomous system of the client address
+// to each entry under a "geo" field, looked up in MaxMind GeoLite2 or
+// GeoIP2 databases.
+type GeoIP struct {
+	// A City or Country database.
+	Database string `json:"database,omitempty"`
+	// An optional ASN database.
+	ASNDatabase string `json:"asn_database,omitempty"`
+}
+
+// geoIPReader holds the open databases of a GeoIP config. It belongs to
+// the writer, which can outlive the config it was opened by.
+type geoIPReader struct {
+	db    *geoip2.Reader
+	asnDB *geoip2.Reader
+}
+
+func parseGeoIP(d *caddyfile.Dispenser) (*GeoIP, error) {
+	g := &GeoIP{}
+
+	if d.NextArg() {
+		g.Database = d.Val()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "database":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			g.Database = d.Val()
+
+		case "asn_database":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			g.ASNDatabase = d.Val()
+
+		default:
+			return nil, d.Errf("unrecognized geoip option %q", d.Val())
+		}
+	}
+
+	return g, nil
+}
+
+func (g *GeoIP) provision() error {
+	if g.Database == "" && g.ASNDatabase == "" {
+		return fmt.Errorf("geoip requires a database or asn_database")
+	}
+	return nil
+}
+
+func (g *GeoIP) open() (*geoIPReader, error) {
+	r := &geoIPReader{}
+
+	var err error
+	if g.Database != "" {
+		if r.db, err = geoip2.Open(g.Database); err != nil {
+			return nil, fmt.Errorf("opening geoip database: %v", err)
+		}
+	}
+
+	if g.ASNDatabase != "" {
+		if r.asnDB, err = geoip2.Open(g.ASNDatabase); err != nil {
+			r.close()
+			return nil, fmt.Errorf("opening geoip asn_database: %v", err)
+		}
+	}
+
+	return r, nil
+}
+
+func (r *geoIPReader) close() {
+	if r.db != nil {
+		r.db.Close()
+	}
+	if r.asnDB != nil {
+		r.asnDB.Close()
+	}
+}
+
+// enrich looks up the client address of an entry, preferring client_ip
+// over remote_ip.
+func (r *geoIPReader) enrich(entry map[string]interface{}) {
+	ip := net.ParseIP(lookupString(entry, "request", "client_ip"))
+	if ip == nil {
+		ip = net.ParseIP(lookupString(entry, "request", "remote_ip"))
+	}
+	if ip == nil {
+		return
+	}
+
+	geo := map[string]interface{}{}
+
+	if r.db != nil {
+		if city, err := r.db.City(ip); err == nil {
+			if city.Country.IsoCode != "" {
+				geo["country_code"] = city.Country.IsoCode
+				geo["country"] = city.Country.Names["en"]
+			}
+			if name := city.City.Names["en"]; name != "" {
+				geo["city"] = name
+			}
+			if city.Location.Latitude != 0 || city.Location.Longitude != 0 {
+				// GeoJSON, so the field can carry a 2dsphere index
+				geo["location"] = map[string]interface{}{
+					"type":        "Point",
+					"coordinates": []float64{city.Location.Longitude, city.Location.Latitude},
+				}
+			}
+		}
+	}
+
+	if r.asnDB != nil {
+		if asn, err := r.asnDB.ASN(ip); err == nil && asn.AutonomousSystemNumber != 0 {
+			geo["asn"] = asn.AutonomousSystemNumber
+			geo["as_org"] = asn.AutonomousSystemOrganization
+		}
+	}
+
+	if len(geo) > 0 {
+		entry["geo"] = geo
+	}
+}
@@ -0,0 +1,189 @@
+package mongo_log
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/dustin/go-humanize"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultGridFSThreshold = 256 << 10
+	defaultGridFSBucket    = "bodies"
+)
+
+// GridFS moves request and response bodies larger than Threshold out of
+// the log document into a GridFS bucket, leaving a reference behind.
+type GridFS struct {
+	Threshold int64  `json:"threshold,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+
+	// Entry paths holding bodies, whose content type is read from the
+	// request or response headers respectively. Bodies are offloaded
+	// after transforms and encryption, so the paths are those of the
+	// transformed entry and what is uploaded is redacted and encrypted.
+	RequestFields  []string `json:"request_fields,omitempty"`
+	ResponseFields []string `json:"response_fields,omitempty"`
+}
+
+func parseGridFS(d *caddyfile.Dispenser) (*GridFS, error) {
+	g := &GridFS{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "threshold":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			size, err := humanize.ParseBytes(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid gridfs threshold %q: %v", d.Val(), err)
+			}
+			g.Threshold = int64(size)
+
+		case "bucket":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			g.Bucket = d.Val()
+
+		case "request_fields":
+			g.RequestFields = append(g.RequestFields, d.RemainingArgs()...)
+
+		case "response_fields":
+			g.ResponseFields = append(g.ResponseFields, d.RemainingArgs()...)
+
+		default:
+			return nil, d.Errf("unrecognized gridfs option %q", d.Val())
+		}
+	}
+
+	return g, nil
+}
+
+func (g *GridFS) provision() {
+	if g.Threshold <= 0 {
+		g.Threshold = defaultGridFSThreshold
+	}
+
+	if g.Bucket == "" {
+		g.Bucket = defaultGridFSBucket
+	}
+
+	if len(g.RequestFields) == 0 && len(g.ResponseFields) == 0 {
+		g.RequestFields = []string{"req_body"}
+		g.ResponseFields = []string{"resp_body"}
+	}
+}
+
+// bucketFor returns the GridFS bucket in database, cached per client.
+func (mWrite *mongoWriter) bucketFor(database string) (*gridfs.Bucket, error) {
+	mWrite.connMu.RLock()
+	client := mWrite.client
+	bucket := mWrite.buckets[database]
+	mWrite.connMu.RUnlock()
+
+	if bucket != nil {
+		return bucket, nil
+	}
+
+	if client == nil {
+		return nil, errNotConnected
+	}
+
+	bucket, err := gridfs.NewBucket(client.Database(database), options.GridFSBucket().SetName(mWrite.config.GridFS.Bucket))
+	if err != nil {
+		return nil, err
+	}
+
+	mWrite.connMu.Lock()
+	if mWrite.client == client {
+		if mWrite.buckets == nil {
+			mWrite.buckets = map[string]*gridfs.Bucket{}
+		}
+		mWrite.buckets[database] = bucket
+	}
+	mWrite.connMu.Unlock()
+
+	return bucket, nil
+}
+
+// offloadBodies uploads oversized bodies of an entry to GridFS and replaces
+// them with a {gridfs_id, size, content_type} reference. Bodies that cannot
+// be uploaded stay inline.
+func (mWrite *mongoWriter) offloadBodies(entry map[string]interface{}, database string) {
+	g := mWrite.config.GridFS
+
+	offload := func(field string, headers []string) {
+		path := splitPath(field)
+
+		body, ok := lookup(entry, path...)
+		if !ok {
+			return
+		}
+
+		// encrypted bodies are uploaded as their ciphertext
+		var data []byte
+		metadata := bson.M{"field": field}
+		switch body := body.(type) {
+		case string:
+			data = []byte(body)
+		case primitive.Binary:
+			data = body.Data
+			metadata["encrypted"] = true
+		}
+		if int64(len(data)) <= g.Threshold {
+			return
+		}
+
+		contentType := headerValue(entry, append(headers, "Content-Type")...)
+		metadata["content_type"] = contentType
+
+		bucket, err := mWrite.bucketFor(database)
+		if err != nil {
+			mWrite.logger.Error("Could not store body in GridFS", zap.String("field", field), zap.Error(err))
+			return
+		}
+
+		filename := fmt.Sprintf("%d-%s", time.Now().UnixNano(), field)
+		id, err := bucket.UploadFromStream(filename, bytes.NewReader(data), options.GridFSUpload().
+			SetMetadata(metadata))
+		if err != nil {
+			mWrite.logger.Error("Could not store body in GridFS", zap.String("field", field), zap.Error(err))
+			return
+		}
+
+		setPath(entry, path, map[string]interface{}{
+			"gridfs_id":    id,
+			"size":         len(data),
+			"content_type": contentType,
+		})
+	}
+
+	for _, field := range g.RequestFields {
+		offload(field, []string{"request", "headers"})
+	}
+
+	for _, field := range g.ResponseFields {
+		offload(field, []string{"resp_headers"})
+	}
+}
+
+// headerValue returns the first value of a logged header.
+func headerValue(entry map[string]interface{}, path ...string) string {
+	v, _ := lookup(entry, path...)
+	if values, ok := v.([]interface{}); ok && len(values) > 0 {
+		s, _ := values[0].(string)
+		return s
+	}
+	return ""
+}
@@ -0,0 +1,166 @@
+package mongo_log
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(MongoLogHealth{})
+	httpcaddyfile.RegisterHandlerDirective("mongo_log_health", parseHealthCaddyfile)
+	// like the other mongo_log responders, ahead of catch-all respond,
+	// abort and error directives
+	httpcaddyfile.RegisterDirectiveOrder("mongo_log_health", httpcaddyfile.Before, "abort")
+}
+
+// MongoLogHealth responds 200 when the logging pipeline is healthy and 503
+// otherwise, with the writer statuses as JSON, for load balancers and
+// external monitors. A writer is healthy while connected with its breaker
+// closed and, with MaxInsertAge, while its last insert is recent enough.
+type MongoLogHealth struct {
+	// The writer to check, named by database.collection. Empty checks
+	// every open writer.
+	Writer string `json:"writer,omitempty"`
+
+	// Fail once the last successful insert is older than this. Writers
+	// that have not inserted anything yet are not held to it.
+	MaxInsertAge caddy.Duration `json:"max_insert_age,omitempty"`
+}
+
+// CaddyModule implements caddy.Module.
+func (MongoLogHealth) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.mongo_log_health",
+		New: func() caddy.Module { return new(MongoLogHealth) },
+	}
+}
+
+type healthStatus struct {
+	Healthy bool           `json:"healthy"`
+	Writers []writerStatus `json:"writers"`
+	Error   string         `json:"error,omitempty"`
+}
+
+func (m MongoLogHealth) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return caddyhttp.Error(http.StatusMethodNotAllowed, nil)
+	}
+
+	health := m.check(time.Now())
+
+	code := http.StatusOK
+	if !health.Healthy {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	return json.NewEncoder(w).Encode(health)
+}
+
+func (m MongoLogHealth) check(now time.Time) healthStatus {
+	var writers []*mongoWriter
+	if m.Writer != "" {
+		if w, ok := lookupWriter(m.Writer); ok {
+			writers = append(writers, w)
+		}
+	} else {
+		for _, name := range writerNames() {
+			if w, ok := lookupWriter(name); ok {
+				writers = append(writers, w)
+			}
+		}
+	}
+
+	health := healthStatus{Healthy: true, Writers: []writerStatus{}}
+	if len(writers) == 0 {
+		health.Healthy = false
+		health.Error = "no mongo_log writer is open"
+		if m.Writer != "" {
+			health.Error = fmt.Sprintf("mongo_log writer %q is not open", m.Writer)
+		}
+		return health
+	}
+
+	for _, w := range writers {
+		s := w.status()
+		health.Writers = append(health.Writers, s)
+
+		switch {
+		case !s.Connected:
+			health.Healthy = false
+			health.Error = fmt.Sprintf("writer %s is not connected", s.Writer)
+		case s.BreakerOpen:
+			health.Healthy = false
+			health.Error = fmt.Sprintf("writer %s has its circuit breaker open", s.Writer)
+		case m.MaxInsertAge > 0 && s.LastInsert != nil && now.Sub(*s.LastInsert) > time.Duration(m.MaxInsertAge):
+			health.Healthy = false
+			health.Error = fmt.Sprintf("writer %s last inserted %s ago", s.Writer, now.Sub(*s.LastInsert).Round(time.Second))
+		}
+	}
+
+	return health
+}
+
+func (m *MongoLogHealth) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			m.Writer = d.Val()
+		}
+
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "writer":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				m.Writer = d.Val()
+
+			case "max_insert_age":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				age, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid max_insert_age %q: %v", d.Val(), err)
+				}
+				m.MaxInsertAge = caddy.Duration(age)
+
+			default:
+				return d.Errf("unrecognized mongo_log_health option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseHealthCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	m := new(MongoLogHealth)
+	err := m.UnmarshalCaddyfile(h.Dispenser)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Interface guards.
+var (
+	_ caddyhttp.MiddlewareHandler = (*MongoLogHealth)(nil)
+	_ caddyfile.Unmarshaler       = (*MongoLogHealth)(nil)
+)
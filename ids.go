@@ -0,0 +1,164 @@
+package mongo_log
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	idFormatUUIDv4    = "uuidv4"
+	idFormatUUIDv7    = "uuidv7"
+	idFormatULID      = "ulid"
+	idFormatKSUID     = "ksuid"
+	idFormatSnowflake = "snowflake"
+)
+
+const (
+	crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	base62Alphabet    = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	ksuidEpoch     = 1400000000
+	snowflakeEpoch = 1288834974657
+	snowflakeNodes = 1 << 10
+)
+
+// newIdGenerator returns a generator for the given request ID format.
+// ULIDs, KSUIDs and snowflakes sort by creation time.
+func newIdGenerator(format string, nodeId *int64) (func() string, error) {
+	switch format {
+	case "", idFormatUUIDv7:
+		return func() string {
+			uid, _ := uuid.NewV7()
+			return uid.String()
+		}, nil
+
+	case idFormatUUIDv4:
+		return func() string { return uuid.NewString() }, nil
+
+	case idFormatULID:
+		return newULID, nil
+
+	case idFormatKSUID:
+		return newKSUID, nil
+
+	case idFormatSnowflake:
+		node := defaultSnowflakeNode()
+		if nodeId != nil {
+			node = *nodeId
+		}
+		if node < 0 || node >= snowflakeNodes {
+			return nil, fmt.Errorf("snowflake node id %d out of range [0, %d)", node, snowflakeNodes)
+		}
+		s := &snowflake{node: node}
+		return s.next, nil
+	}
+
+	return nil, fmt.Errorf("unknown id_format %q", format)
+}
+
+// newULID returns a 48 bit millisecond timestamp followed by 80 random
+// bits, in Crockford's base32.
+func newULID() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(b[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:], uint32(ms))
+	rand.Read(b[6:])
+
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+
+	var out [26]byte
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// newKSUID returns a 32 bit second timestamp followed by 128 random bits,
+// in base62.
+func newKSUID() string {
+	var b [20]byte
+	binary.BigEndian.PutUint32(b[:], uint32(time.Now().Unix()-ksuidEpoch))
+	rand.Read(b[4:])
+
+	n := new(big.Int).SetBytes(b[:])
+	base := big.NewInt(62)
+	mod := new(big.Int)
+
+	var out [27]byte
+	for i := len(out) - 1; i >= 0; i-- {
+		n.DivMod(n, base, mod)
+		out[i] = base62Alphabet[mod.Int64()]
+	}
+	return string(out[:])
+}
+
+// snowflake generates 64 bit IDs from a 41 bit millisecond timestamp, a 10
+// bit node id and a 12 bit per-millisecond sequence.
+type snowflake struct {
+	mu   sync.Mutex
+	node int64
+	last int64
+	seq  int64
+}
+
+func (s *snowflake) next() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixMilli() - snowflakeEpoch
+	if now <= s.last {
+		// same millisecond, or the clock went backwards
+		now = s.last
+		s.seq = (s.seq + 1) & 0xfff
+		if s.seq == 0 {
+			now++
+		}
+	} else {
+		s.seq = 0
+	}
+	s.last = now
+
+	return strconv.FormatInt(now<<22|s.node<<12|s.seq, 10)
+}
+
+// defaultSnowflakeNode derives a node id from the hostname.
+func defaultSnowflakeNode() int64 {
+	host, _ := os.Hostname()
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	return int64(h.Sum32() % snowflakeNodes)
+}
+
+const maxIncomingIdLength = 128
+
+// validIncomingId reports whether id, taken from a request header, is safe
+// to log and echo back as the request ID.
+func validIncomingId(id string) bool {
+	if id == "" || len(id) > maxIncomingIdLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
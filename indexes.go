@@ -0,0 +1,112 @@
+package mongo_log
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Returned by createIndexes when an index exists with different options.
+const (
+	codeIndexOptionsConflict  = 85
+	codeIndexKeySpecsConflict = 86
+)
+
+func isIndexConflict(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == codeIndexOptionsConflict || cmdErr.Code == codeIndexKeySpecsConflict
+	}
+	return false
+}
+
+// ensureIndexes creates the indexes implied by the config. It is safe to
+// call on every connect.
+func (l *MongoLog) ensureIndexes(ctx context.Context, collection *mongo.Collection) error {
+	// time-series collections expire documents through a collection option
+	if l.Retention > 0 && l.TimeSeries == nil {
+		if err := ensureTTLIndex(ctx, collection, "date", time.Duration(l.Retention), l.Compat == ""); err != nil {
+			return err
+		}
+	}
+
+	if len(l.Expire) > 0 {
+		if err := ensureTTLIndex(ctx, collection, expireAtField, 0, l.Compat == ""); err != nil {
+			return err
+		}
+	}
+
+	// two_phase upserts by request ID; unique so concurrent upserts of a
+	// request cannot both insert, over the documents that have one
+	if l.TwoPhase {
+		if _, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: requestIdField, Value: 1}},
+			Options: options.Index().
+				SetName(requestIdField + "_unique").
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{requestIdField: bson.M{"$type": "string"}}),
+		}); err != nil {
+			return err
+		}
+	}
+
+	if l.WildcardIndex {
+		if _, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: l.metadataField("$**"), Value: 1}}}); err != nil {
+			return err
+		}
+	}
+
+	if len(l.Indexes) > 0 {
+		models := make([]mongo.IndexModel, 0, len(l.Indexes))
+		for _, fields := range l.Indexes {
+			models = append(models, mongo.IndexModel{Keys: indexKeys(fields)})
+		}
+
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexKeys turns ["metadata.status", "-date"] into an index key document.
+func indexKeys(fields []string) bson.D {
+	keys := make(bson.D, 0, len(fields))
+	for _, field := range fields {
+		if name, ok := strings.CutPrefix(field, "-"); ok {
+			keys = append(keys, bson.E{Key: name, Value: -1})
+		} else {
+			keys = append(keys, bson.E{Key: field, Value: 1})
+		}
+	}
+	return keys
+}
+
+// ensureTTLIndex creates a TTL index on field, updating the expiry of an
+// existing one through collMod if the retention changed and collMod is
+// allowed.
+func ensureTTLIndex(ctx context.Context, collection *mongo.Collection, field string, retention time.Duration, collMod bool) error {
+	seconds := int32(retention / time.Second)
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(seconds),
+	})
+	if !isIndexConflict(err) || !collMod {
+		return err
+	}
+
+	return collection.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: collection.Name()},
+		{Key: "index", Value: bson.D{
+			{Key: "keyPattern", Value: bson.D{{Key: field, Value: 1}}},
+			{Key: "expireAfterSeconds", Value: seconds},
+		}},
+	}).Err()
+}
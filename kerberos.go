@@ -0,0 +1,114 @@
+package mongo_log
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+const authGSSAPI = "GSSAPI"
+
+// Kerberos configures GSSAPI authentication, selected with auth_mechanism
+// GSSAPI and the principal as username. The driver only supports it when
+// Caddy is built with cgo and the gssapi build tag, against libkrb5.
+type Kerberos struct {
+	// Service principal name of the servers, "mongodb" by default.
+	ServiceName  string `json:"service_name,omitempty"`
+	ServiceRealm string `json:"service_realm,omitempty"`
+	ServiceHost  string `json:"service_host,omitempty"`
+
+	CanonicalizeHostName bool `json:"canonicalize_host_name,omitempty"`
+
+	// Client keytab and credential cache. They are read through the
+	// KRB5_CLIENT_KTNAME and KRB5CCNAME environment variables, which apply
+	// to the whole process.
+	Keytab          string `json:"keytab,omitempty"`
+	CredentialCache string `json:"credential_cache,omitempty"`
+}
+
+func parseKerberos(d *caddyfile.Dispenser) (*Kerberos, error) {
+	k := &Kerberos{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		option := d.Val()
+
+		if option == "canonicalize_host_name" {
+			k.CanonicalizeHostName = true
+			if d.NextArg() {
+				canonicalize, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return nil, d.Errf("invalid canonicalize_host_name %q: %v", d.Val(), err)
+				}
+				k.CanonicalizeHostName = canonicalize
+			}
+			continue
+		}
+
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+
+		switch option {
+		case "service_name":
+			k.ServiceName = d.Val()
+		case "service_realm":
+			k.ServiceRealm = d.Val()
+		case "service_host":
+			k.ServiceHost = d.Val()
+		case "keytab":
+			k.Keytab = d.Val()
+		case "credential_cache":
+			k.CredentialCache = d.Val()
+		default:
+			return nil, d.Errf("unrecognized kerberos option %q", option)
+		}
+	}
+
+	return k, nil
+}
+
+// provision points libkrb5 at the configured keytab and credential cache.
+func (k *Kerberos) provision() error {
+	for env, path := range map[string]string{
+		"KRB5_CLIENT_KTNAME": k.Keytab,
+		"KRB5CCNAME":         k.CredentialCache,
+	} {
+		if path == "" {
+			continue
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("kerberos: %v", err)
+		}
+		if err := os.Setenv(env, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mechanismProperties returns the GSSAPI properties for the credential.
+func (k *Kerberos) mechanismProperties() map[string]string {
+	props := map[string]string{}
+	if k == nil {
+		return props
+	}
+
+	if k.ServiceName != "" {
+		props["SERVICE_NAME"] = k.ServiceName
+	}
+	if k.ServiceRealm != "" {
+		props["SERVICE_REALM"] = k.ServiceRealm
+	}
+	if k.ServiceHost != "" {
+		props["SERVICE_HOST"] = k.ServiceHost
+	}
+	if k.CanonicalizeHostName {
+		props["CANONICALIZE_HOST_NAME"] = "true"
+	}
+
+	return props
+}
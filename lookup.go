@@ -0,0 +1,112 @@
+package mongo_log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(MongoLogLookup{})
+	httpcaddyfile.RegisterHandlerDirective("mongo_log_lookup", parseLookupCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("mongo_log_lookup", httpcaddyfile.Before, "abort")
+}
+
+// MongoLogLookup responds with the stored documents of one request, given
+// its ID as the id query parameter or the last path segment, e.g.
+// GET /requests/01HZX3... An index on request_id keeps lookups fast.
+type MongoLogLookup struct {
+	// The writer to search, named by database.collection. May be empty
+	// when only one writer is open.
+	Writer string `json:"writer,omitempty"`
+}
+
+// CaddyModule implements caddy.Module.
+func (MongoLogLookup) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.mongo_log_lookup",
+		New: func() caddy.Module { return new(MongoLogLookup) },
+	}
+}
+
+func (m MongoLogLookup) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return caddyhttp.Error(http.StatusMethodNotAllowed, nil)
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		id = path.Base(r.URL.Path)
+	}
+	if id == "" || id == "/" || id == "." {
+		return caddyhttp.Error(http.StatusBadRequest, fmt.Errorf("missing request id"))
+	}
+
+	docs, err := queryLogs(r.Context(), url.Values{
+		"writer":     {m.Writer},
+		"request_id": {id},
+		"limit":      {fmt.Sprint(maxQueryLimit)},
+	})
+	if err != nil {
+		var apiErr caddy.APIError
+		if errors.As(err, &apiErr) {
+			return caddyhttp.Error(apiErr.HTTPStatus, apiErr.Err)
+		}
+		return err
+	}
+
+	if len(docs) == 0 {
+		return caddyhttp.Error(http.StatusNotFound, fmt.Errorf("no log documents for request %q", id))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(docs)
+}
+
+func (m *MongoLogLookup) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			m.Writer = d.Val()
+		}
+
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "writer":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				m.Writer = d.Val()
+
+			default:
+				return d.Errf("unrecognized mongo_log_lookup option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseLookupCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	m := new(MongoLogLookup)
+	err := m.UnmarshalCaddyfile(h.Dispenser)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Interface guards.
+var (
+	_ caddyhttp.MiddlewareHandler = (*MongoLogLookup)(nil)
+	_ caddyfile.Unmarshaler       = (*MongoLogLookup)(nil)
+)
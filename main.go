@@ -2,37 +2,91 @@ package mongo_log
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
-	"github.com/google/uuid"
+	"github.com/caddyserver/certmagic"
+	"github.com/dustin/go-humanize"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/time/rate"
 )
 
 func init() {
 	caddy.RegisterModule(MongoLog{})
 	caddy.RegisterModule(MongoReqId{})
+	caddy.RegisterModule(adminAPI{})
 	httpcaddyfile.RegisterHandlerDirective("mongo_request_id", parseCaddyfile)
+	// right after tracing, so the ID and any span are set for everything else
+	httpcaddyfile.RegisterDirectiveOrder("mongo_request_id", httpcaddyfile.After, "tracing")
 }
 
+const (
+	defaultRequestIdHeader = "X-Request-Id"
+	requestIdField         = "request_id"
+)
+
 type MongoReqId struct {
 	logger *zap.Logger
 	Header string `json:"header,omitempty"`
+
+	// One of uuidv7 (default), uuidv4, ulid, ksuid or snowflake.
+	IdFormat string `json:"id_format,omitempty"`
+	// Snowflake node id, derived from the hostname when unset.
+	NodeId *int64 `json:"node_id,omitempty"`
+
+	// Also set the ID on the request headers, so proxied upstreams see it.
+	Propagate bool `json:"propagate,omitempty"`
+
+	// Reuse the ID a client or proxy in front already sent in Header,
+	// when it is at most 128 letters, digits or "-_.:" characters.
+	TrustIncoming bool `json:"trust_incoming,omitempty"`
+
+	// Store an in-progress document when the request starts, through the
+	// two_phase writer named RecordWriter, or the only one open.
+	RecordStart  bool   `json:"record_start,omitempty"`
+	RecordWriter string `json:"record_writer,omitempty"`
+
+	// Continue or start a W3C trace: log its trace_id and span_id and pass
+	// the new traceparent upstream.
+	Traceparent bool `json:"traceparent,omitempty"`
+
+	newId func() string
 }
 
 func (m *MongoReqId) Provision(ctx caddy.Context) error {
 	m.logger = ctx.Logger(m)
+
+	if m.Header == "" {
+		m.Header = defaultRequestIdHeader
+	}
+
+	newId, err := newIdGenerator(m.IdFormat, m.NodeId)
+	if err != nil {
+		return err
+	}
+	m.newId = newId
 	return nil
 }
 func (l *MongoReqId) String() string {
@@ -44,15 +98,50 @@ func (l *MongoReqId) WriterKey() string {
 }
 func (m MongoReqId) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
 	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
-	uid, _ := uuid.NewV7()
-
-	id := uid.String()
+	id := r.Header.Get(m.Header)
+	if !m.TrustIncoming || !validIncomingId(id) {
+		id = m.newId()
+	}
 	repl.Set("http.mongo_request_id", id)
 
-	data, _ := io.ReadAll(r.Body)
-	dataResp, _ := io.ReadAll(r.Response.Body)
-	m.logger.Debug("mongolog", zap.String("req_id", id), zap.String("req_body", string(data)), zap.String("resp_body", string(dataResp)))
-	w.Header().Add("X-Request-Id", id)
+	if m.RecordStart {
+		if writer, ok := lookupWriter(m.RecordWriter); ok && writer.config.TwoPhase {
+			writer.requestStarted(id, r)
+		}
+	}
+
+	m.logger.Debug("mongolog", zap.String("req_id", id))
+
+	w.Header().Add(m.Header, id)
+
+	if m.Propagate {
+		r.Header.Set(m.Header, id)
+	}
+
+	var traceId, spanId string
+	if m.Traceparent {
+		var traceparent string
+		traceparent, traceId, spanId = newTraceparent(r.Header.Get(traceparentHeader))
+		r.Header.Set(traceparentHeader, traceparent)
+
+		repl.Set("http.mongo_trace_id", traceId)
+		repl.Set("http.mongo_span_id", spanId)
+	}
+
+	// an active OpenTelemetry span, e.g. from the tracing handler, wins
+	if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+		traceId, spanId = sc.TraceID().String(), sc.SpanID().String()
+	}
+
+	// lifted by the mongo writer into top-level document fields
+	if extra, ok := r.Context().Value(caddyhttp.ExtraLogFieldsCtxKey).(*caddyhttp.ExtraLogFields); ok {
+		extra.Set(zap.String(requestIdField, id))
+		if traceId != "" {
+			extra.Set(zap.String(traceIdField, traceId))
+			extra.Set(zap.String(spanIdField, spanId))
+		}
+	}
+
 	return next.ServeHTTP(w, r)
 }
 
@@ -63,7 +152,95 @@ func (m MongoReqId) CaddyModule() caddy.ModuleInfo {
 		New: func() caddy.Module { return new(MongoReqId) },
 	}
 }
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens. Syntax:
+//
+//	mongo_request_id [<header>] {
+//		header         <name>
+//		id_format      uuidv7|uuidv4|ulid|ksuid|snowflake
+//		node_id        <id>
+//		trust_incoming [<bool>]
+//		record_start   [<writer>]
+//		propagate      [<bool>]
+//		traceparent    [<bool>]
+//	}
 func (m *MongoReqId) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			m.Header = d.Val()
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "header":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				m.Header = d.Val()
+
+			case "id_format":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				m.IdFormat = d.Val()
+
+			case "node_id":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				node, err := strconv.ParseInt(d.Val(), 10, 64)
+				if err != nil {
+					return d.Errf("invalid node_id %q: %v", d.Val(), err)
+				}
+				m.NodeId = &node
+
+			case "propagate":
+				m.Propagate = true
+				if d.NextArg() {
+					propagate, err := strconv.ParseBool(d.Val())
+					if err != nil {
+						return d.Errf("invalid propagate %q: %v", d.Val(), err)
+					}
+					m.Propagate = propagate
+				}
+
+			case "record_start":
+				m.RecordStart = true
+				if d.NextArg() {
+					m.RecordWriter = d.Val()
+				}
+
+			case "trust_incoming":
+				m.TrustIncoming = true
+				if d.NextArg() {
+					trust, err := strconv.ParseBool(d.Val())
+					if err != nil {
+						return d.Errf("invalid trust_incoming %q: %v", d.Val(), err)
+					}
+					m.TrustIncoming = trust
+				}
+
+			case "traceparent":
+				m.Traceparent = true
+				if d.NextArg() {
+					traceparent, err := strconv.ParseBool(d.Val())
+					if err != nil {
+						return d.Errf("invalid traceparent %q: %v", d.Val(), err)
+					}
+					m.Traceparent = traceparent
+				}
+
+			default:
+				return d.Errf("unrecognized mongo_request_id option %q", d.Val())
+			}
+		}
+	}
 	return nil
 }
 func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
@@ -82,7 +259,278 @@ type MongoLog struct {
 	Collection string            `json:"collection,omitempty"`
 	Tags       map[string]string `json:"tags,omitempty"`
 
-	logger *zap.Logger
+	// Tried in order once the current endpoint has been unreachable for
+	// FailoverAfter.
+	FallbackUris  []string       `json:"fallback_uris,omitempty"`
+	FailoverAfter caddy.Duration `json:"failover_after,omitempty"`
+
+	// Resolve mongodb+srv records during validation.
+	ValidateDNS bool `json:"validate_dns,omitempty"`
+
+	MongoUriFile string `json:"mongo_uri_file,omitempty"`
+	Username     string `json:"username,omitempty"`
+	UsernameFile string `json:"username_file,omitempty"`
+	Password     string `json:"password,omitempty"`
+	PasswordFile string `json:"password_file,omitempty"`
+
+	BatchSize     int            `json:"batch_size,omitempty"`
+	FlushInterval caddy.Duration `json:"flush_interval,omitempty"`
+
+	// How long closing the writer, on reload or shutdown, waits for queued
+	// and batched entries to be written.
+	ShutdownTimeout caddy.Duration `json:"shutdown_timeout,omitempty"`
+
+	QueueSize int `json:"queue_size,omitempty"`
+
+	// Number of goroutines decoding entries and inserting full batches,
+	// so one slow insert does not hold up the rest of the queue.
+	Workers int `json:"workers,omitempty"`
+
+	// What Write does when the queue is full: block (the default) until
+	// there is room, trading request latency for delivery, or drop_newest
+	// or drop_oldest to never hold up the logging pipeline. BlockTimeout
+	// bounds how long block waits before dropping the entry.
+	Overflow     string         `json:"overflow,omitempty"`
+	BlockTimeout caddy.Duration `json:"block_timeout,omitempty"`
+
+	MaxRetries    int            `json:"max_retries,omitempty"`
+	RetryInterval caddy.Duration `json:"retry_interval,omitempty"`
+
+	Breaker *Breaker `json:"breaker,omitempty"`
+
+	// Post to a webhook while inserts keep failing or the queue backs up.
+	Alert *Alert `json:"alert,omitempty"`
+
+	// Warn about inserts taking longer than this, e.g. 250ms.
+	SlowInsertThreshold caddy.Duration `json:"slow_insert_threshold,omitempty"`
+
+	SpoolDir     string `json:"spool_dir,omitempty"`
+	SpoolMaxSize int64  `json:"spool_max_size,omitempty"`
+
+	// Publish batches that exhaust their retries to kafka://broker/topic
+	// or nats://host/subject, before falling back to the spool.
+	Fallback string `json:"fallback,omitempty"`
+
+	WriteConcern *WriteConcern `json:"write_concern,omitempty"`
+	TLS          *TLSConfig    `json:"tls,omitempty"`
+
+	MaxPoolSize            uint64         `json:"max_pool_size,omitempty"`
+	MinPoolSize            uint64         `json:"min_pool_size,omitempty"`
+	ConnectTimeout         caddy.Duration `json:"connect_timeout,omitempty"`
+	ServerSelectionTimeout caddy.Duration `json:"server_selection_timeout,omitempty"`
+	SocketTimeout          caddy.Duration `json:"socket_timeout,omitempty"`
+
+	// Connect synchronously when the writer is opened so an unreachable
+	// server fails the config load, bounded by connect_timeout. On by default.
+	EagerConnect *bool `json:"eager_connect,omitempty"`
+
+	// Force retryable writes and reads on or off. When unset the URI and
+	// driver defaults apply, except that compat disables retryable writes.
+	RetryWrites *bool `json:"retry_writes,omitempty"`
+	RetryReads  *bool `json:"retry_reads,omitempty"`
+
+	// Adapt to a MongoDB compatible service: "documentdb" or "cosmosdb".
+	// Disables retryable writes and features those services lack.
+	Compat string `json:"compat,omitempty"`
+
+	// Log and count server and topology changes and failed heartbeats
+	// seen by the driver.
+	MonitorServers bool `json:"monitor_servers,omitempty"`
+
+	// Client name shown by currentOp, server logs and Atlas metrics,
+	// "caddy-mongo-logger/<version>" by default.
+	AppName string `json:"app_name,omitempty"`
+
+	// Wire compressors in order of preference: zstd, snappy or zlib.
+	Compressors []string `json:"compressors,omitempty"`
+
+	// SCRAM-SHA-256, SCRAM-SHA-1, MONGODB-X509 or MONGODB-AWS. Username
+	// and password alone negotiate a SCRAM mechanism with the server.
+	AuthMechanism string `json:"auth_mechanism,omitempty"`
+	// Database holding the user's credentials, "admin" by default for SCRAM.
+	AuthSource string    `json:"auth_source,omitempty"`
+	Kerberos   *Kerberos `json:"kerberos,omitempty"`
+	CertFile   string    `json:"cert_file,omitempty"`
+	KeyFile    string    `json:"key_file,omitempty"`
+
+	TimeSeries *TimeSeries `json:"timeseries,omitempty"`
+
+	Capped  bool  `json:"capped,omitempty"`
+	MaxSize int64 `json:"max_size,omitempty"`
+	MaxDocs int64 `json:"max_docs,omitempty"`
+
+	Retention caddy.Duration `json:"retention,omitempty"`
+
+	// How long to keep documents by status code ("404"), class ("5xx"),
+	// level ("error") or "default", the most specific rule winning. The
+	// time is stored in an expireAt field with a TTL index.
+	Expire map[string]caddy.Duration `json:"expire,omitempty"`
+
+	// Delete old documents from a background job instead of a TTL index.
+	Purge *Purge `json:"purge,omitempty"`
+
+	// Export old documents to files or object storage, then delete them.
+	Archive *Archive `json:"archive,omitempty"`
+
+	// Maintain hourly request aggregates in a rollup collection.
+	Rollup *Rollup `json:"rollup,omitempty"`
+
+	// Secondary indexes, each a list of dot-path fields. A leading "-"
+	// makes that key descending.
+	Indexes [][]string `json:"indexes,omitempty"`
+
+	// Create a wildcard index over the metadata sub-document, or the
+	// whole document with top_level, so queries on any field use an index.
+	WildcardIndex bool `json:"wildcard_index,omitempty"`
+
+	// Shard the log collections on these dot-path fields when writing
+	// through a mongos. A ":hashed" suffix hashes that field, e.g.
+	// ["metadata.request.host:hashed", "date"].
+	ShardKey []string `json:"shard_key,omitempty"`
+
+	// Maps request hosts to the collection their logs are written to.
+	Routes map[string]string `json:"routes,omitempty"`
+
+	// Maps log levels, e.g. "error", to a collection. Level routes take
+	// precedence over logger and host routes.
+	LevelRoutes map[string]string `json:"level_routes,omitempty"`
+
+	// Maps logger names, e.g. "tls" or "http.log.access", to a database
+	// and collection. A name also matches its child loggers; the longest
+	// match wins. Logger routes take precedence over host routes.
+	LoggerRoutes map[string]LoggerRoute `json:"logger_routes,omitempty"`
+
+	// Write each tenant's entries to its own database or collections,
+	// after the routes above.
+	Tenant *Tenant `json:"tenant,omitempty"`
+
+	// Keep one document per request ID: mongo_request_id's record_start
+	// stores it in progress when the request starts, and the access log
+	// entry completes it with the response.
+	TwoPhase bool `json:"two_phase,omitempty"`
+
+	// Store metadata as a single level of keys joined by FlattenSeparator
+	// instead of nested sub-documents.
+	Flatten          bool   `json:"flatten,omitempty"`
+	FlattenSeparator string `json:"flatten_separator,omitempty"`
+
+	// Store the entry fields at the top level of the document, e.g.
+	// status rather than metadata.status. Fields that would clash with
+	// the document's own, such as the date field or _id, stay under
+	// metadata.
+	TopLevel bool `json:"top_level,omitempty"`
+
+	// Request paths never stored, e.g. "/healthz" or "/static/*". A *
+	// matches any characters, "/" included. Handlers such as mongo_body
+	// take Caddy request matchers instead.
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Only store entries of loggers matching IncludeLoggers, when set, and
+	// not matching ExcludeLoggers, e.g. "http.log.access.*". A name also
+	// matches its child loggers.
+	IncludeLoggers []string `json:"include_loggers,omitempty"`
+	ExcludeLoggers []string `json:"exclude_loggers,omitempty"`
+
+	// Ignore entries below this level, e.g. "warn", when the logger also
+	// feeds writers that want them.
+	MinLevel string `json:"min_level,omitempty"`
+
+	// Fraction of entries to store, from 0 to 1. Unset stores every entry.
+	SampleRate *float64 `json:"sample_rate,omitempty"`
+
+	// Sample rate for 1xx-3xx entries below warn level. When set, error
+	// responses and warnings are always stored.
+	SuccessSampleRate *float64 `json:"success_sample_rate,omitempty"`
+
+	// Sample rates per status code ("404") or class ("5xx"), taking
+	// precedence over the rates above.
+	Sample map[string]float64 `json:"sample,omitempty"`
+
+	// Entries stored per second beyond which entries are dropped.
+	MaxWritesPerSecond float64 `json:"max_writes_per_second,omitempty"`
+
+	Redact *Redact `json:"redact,omitempty"`
+
+	// "truncate" or "hash" client addresses before they are stored. hash
+	// requires AnonymizeIPSalt.
+	AnonymizeIP     string `json:"anonymize_ip,omitempty"`
+	AnonymizeIPSalt string `json:"anonymize_ip_salt,omitempty"`
+
+	// Enrich entries with the location of the client address.
+	GeoIP *GeoIP `json:"geoip,omitempty"`
+
+	// Parse the User-Agent header into browser, os, device and bot fields.
+	ParseUserAgent bool `json:"parse_user_agent,omitempty"`
+
+	// Dot-paths into the log entry, e.g. "request.headers.Cookie". When
+	// IncludeFields is set only those fields are kept.
+	IncludeFields []string `json:"include_fields,omitempty"`
+	ExcludeFields []string `json:"exclude_fields,omitempty"`
+
+	// Store entries with Elastic Common Schema field names.
+	ECS bool `json:"ecs,omitempty"`
+
+	// Moves fields from one dot-path to another after filtering.
+	Rename map[string]string `json:"rename,omitempty"`
+
+	// Converts stored fields to int, double, bool, string or, for
+	// durations in seconds, milliseconds as a double. Paths name fields
+	// after transforms such as rename and ecs.
+	Coerce map[string]string `json:"coerce,omitempty"`
+
+	// Timestamp fields stored as BSON dates, so date operators and TTL
+	// indexes work on them. Paths are named like Coerce's.
+	DateFields []string `json:"date_fields,omitempty"`
+
+	// Replaces the {tags, metadata, date} envelope with documents built
+	// from output dot-paths mapped to {entry.<path>} or other
+	// placeholders, and {date}, {tags} or {metadata} for the envelope
+	// parts.
+	Template map[string]string `json:"template,omitempty"`
+
+	// Create collections with a $jsonSchema validator derived from the
+	// envelope, template, coerce and date_fields. "error" rejects
+	// mismatching inserts, "warn" only logs them on the server.
+	SchemaValidation string `json:"schema_validation,omitempty"`
+
+	Encryption *Encryption `json:"encryption,omitempty"`
+
+	GridFS *GridFS `json:"gridfs,omitempty"`
+
+	// Documents encoding to more than MaxDocumentSize bytes, 16MiB by
+	// default, are handled by Oversize: "truncate" their largest strings
+	// (the default), "drop_bodies", store them in "gridfs" behind a stub,
+	// or "reject" them to the dead letter collection.
+	MaxDocumentSize int64  `json:"max_document_size,omitempty"`
+	Oversize        string `json:"oversize,omitempty"`
+
+	// Collection for log lines that are not valid JSON, stored with a raw
+	// field. Defaults to the main collection, or with SchemaValidation,
+	// which dead letters would fail, to the main collection's name with a
+	// _dead_letter suffix.
+	DeadLetter string `json:"dead_letter,omitempty"`
+
+	// Also store certificate lifecycle entries of the tls loggers, e.g.
+	// obtained, renewed, expiring or OCSP updates, as structured
+	// documents in this collection, with the SANs and expiry of new
+	// certificates.
+	TLSEvents string `json:"tls_events,omitempty"`
+
+	// A second log writer, e.g. a file, that receives every stored entry
+	// as JSON, so an outage of mongo does not lose the logs entirely.
+	// Entries are copied after redaction, anonymization, the other
+	// transforms and encryption, so the tee holds no more than mongo.
+	TeeRaw json.RawMessage `json:"tee,omitempty" caddy:"namespace=caddy.logging.writers inline_key=output"`
+
+	logger    *zap.Logger
+	tlsConfig *tls.Config
+	tee       caddy.WriterOpener
+	exclude   []*regexp.Regexp
+	storage   certmagic.Storage
+
+	includeLoggers []*regexp.Regexp
+	excludeLoggers []*regexp.Regexp
+	minLevel       zapcore.Level
 }
 
 // CaddyModule returns the Caddy module information.
@@ -97,8 +545,17 @@ func (l *MongoLog) String() string {
 	return "mongo_log"
 }
 
+// WriterKey identifies the writer by a hash of its whole config, so a
+// reload changing any option opens a new writer, outputs configured
+// differently never share one, and credentials stay out of the key.
 func (l *MongoLog) WriterKey() string {
-	return "mongo_log"
+	config, err := json.Marshal(l)
+	if err != nil {
+		config = []byte(l.MongoUri + "/" + l.Database + "/" + l.Collection)
+	}
+
+	sum := sha256.Sum256(config)
+	return "mongo_log:" + hex.EncodeToString(sum[:])
 }
 
 func (l *MongoLog) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
@@ -115,6 +572,7 @@ func (l *MongoLog) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 			}
 
 			l.MongoUri = d.Val()
+			l.FallbackUris = append(l.FallbackUris, d.RemainingArgs()...)
 		case "collection":
 			if !d.NextArg() {
 				return d.ArgErr()
@@ -141,103 +599,1636 @@ func (l *MongoLog) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				tags[key] = d.Val()
 			}
 			l.Tags = tags
-		}
-	}
 
-	return nil
-}
+		case "fallback_uris":
+			uris := d.RemainingArgs()
+			if len(uris) == 0 {
+				return d.ArgErr()
+			}
 
-func (l *MongoLog) OpenWriter() (io.WriteCloser, error) {
-	writer := &mongoWriter{
-		logger: l.logger,
-	}
+			l.FallbackUris = append(l.FallbackUris, uris...)
 
-	go func() {
-		writer.Open(l)
-	}()
+		case "failover_after":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
 
-	return writer, nil
-}
+			after, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid failover_after %q: %v", d.Val(), err)
+			}
+			l.FailoverAfter = caddy.Duration(after)
 
-func (l *MongoLog) Provision(ctx caddy.Context) error {
-	l.logger = ctx.Logger(l)
+		case "validate_dns":
+			l.ValidateDNS = true
+			if d.NextArg() {
+				validate, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return d.Errf("invalid validate_dns %q: %v", d.Val(), err)
+				}
+				l.ValidateDNS = validate
+			}
 
-	return nil
-}
+		case "mongo_uri_file":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
 
-func (l *MongoLog) Validate() error {
-	if l.MongoUri == "" {
-		return fmt.Errorf("NO HOST SET")
-	}
+			l.MongoUriFile = d.Val()
 
-	if l.Database == "" {
-		return fmt.Errorf("NO DATABASE SET")
-	}
+		case "username":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
 
-	if l.Collection == "" {
-		return fmt.Errorf("NO COLLECTION SET")
-	}
+			l.Username = d.Val()
 
-	if l.Tags == nil {
-		l.Tags = map[string]string{}
-	}
+		case "username_file":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
 
-	return nil
-}
+			l.UsernameFile = d.Val()
 
-func flatten(m map[string]interface{}, fields map[string]interface{}, prefix string) map[string]interface{} {
-	for k, v := range m {
-		key := prefix + k
+		case "password":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
 
-		if v2, ok := v.(map[string]interface{}); ok {
-			flatten(v2, fields, key+"_")
-		} else {
-			fields[key] = v
-		}
-	}
-	return m
-}
+			l.Password = d.Val()
 
-type mongoWriter struct {
-	logger      *zap.Logger
-	measurement string
-	tags        map[string]string
-	client      *mongo.Client
-	collection  *mongo.Collection
-}
+		case "password_file":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
 
-func (mWrite *mongoWriter) Write(p []byte) (n int, err error) {
+			l.PasswordFile = d.Val()
 
-	f := map[string]interface{}{}
-	if err := json.Unmarshal(p, &f); err != nil {
-		mWrite.logger.Error("Unmarshal failed on log", zap.Error((err)))
-	}
+		case "batch_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
 
-	mWrite.collection.InsertOne(context.Background(), bson.M{
-		"tags":     "",
-		"metadata": f,
-		"date":     primitive.NewDateTimeFromTime(time.Now()),
-	})
+			size, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("invalid batch_size %q: %v", d.Val(), err)
+			}
+			l.BatchSize = size
 
-	return
-}
+		case "flush_interval":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
 
-func (mWrite *mongoWriter) Close() error {
-	mWrite.client.Disconnect(context.Background())
-	return nil
-}
+			interval, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid flush_interval %q: %v", d.Val(), err)
+			}
+			l.FlushInterval = caddy.Duration(interval)
 
-func (mWrite *mongoWriter) Open(i *MongoLog) error {
+		case "queue_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
 
-	con, err := mongo.Connect(context.Background(), options.Client().ApplyURI(i.MongoUri))
-	if err != nil {
-		return err
-	}
-	mWrite.client = con
-	mWrite.collection = con.Database(i.Database).Collection(i.Collection)
-	mWrite.tags = i.Tags
+			size, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("invalid queue_size %q: %v", d.Val(), err)
+			}
+			l.QueueSize = size
 
-	return nil
+		case "workers":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			workers, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("invalid workers %q: %v", d.Val(), err)
+			}
+			l.Workers = workers
+
+		case "overflow":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.Overflow = d.Val()
+
+		case "block_timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			timeout, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid block_timeout %q: %v", d.Val(), err)
+			}
+			l.BlockTimeout = caddy.Duration(timeout)
+
+		case "max_retries":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			retries, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("invalid max_retries %q: %v", d.Val(), err)
+			}
+			l.MaxRetries = retries
+
+		case "retry_interval":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			interval, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid retry_interval %q: %v", d.Val(), err)
+			}
+			l.RetryInterval = caddy.Duration(interval)
+
+		case "slow_insert_threshold":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			threshold, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid slow_insert_threshold %q: %v", d.Val(), err)
+			}
+			l.SlowInsertThreshold = caddy.Duration(threshold)
+
+		case "breaker":
+			b, err := parseBreaker(d)
+			if err != nil {
+				return err
+			}
+			l.Breaker = b
+
+		case "alert":
+			a, err := parseAlert(d)
+			if err != nil {
+				return err
+			}
+			l.Alert = a
+
+		case "shutdown_timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			timeout, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid shutdown_timeout %q: %v", d.Val(), err)
+			}
+			l.ShutdownTimeout = caddy.Duration(timeout)
+
+		case "spool_dir":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.SpoolDir = d.Val()
+
+		case "spool_max_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			size, err := humanize.ParseBytes(d.Val())
+			if err != nil {
+				return d.Errf("invalid spool_max_size %q: %v", d.Val(), err)
+			}
+			l.SpoolMaxSize = int64(size)
+
+		case "write_concern":
+			wc, err := parseWriteConcern(d)
+			if err != nil {
+				return err
+			}
+			l.WriteConcern = wc
+
+		case "tls":
+			t, err := parseTLS(d)
+			if err != nil {
+				return err
+			}
+			l.TLS = t
+
+		case "max_pool_size", "min_pool_size":
+			option := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			size, err := strconv.ParseUint(d.Val(), 10, 64)
+			if err != nil {
+				return d.Errf("invalid %s %q: %v", option, d.Val(), err)
+			}
+
+			if option == "max_pool_size" {
+				l.MaxPoolSize = size
+			} else {
+				l.MinPoolSize = size
+			}
+
+		case "connect_timeout", "server_selection_timeout", "socket_timeout":
+			option := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			timeout, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid %s %q: %v", option, d.Val(), err)
+			}
+
+			switch option {
+			case "connect_timeout":
+				l.ConnectTimeout = caddy.Duration(timeout)
+			case "server_selection_timeout":
+				l.ServerSelectionTimeout = caddy.Duration(timeout)
+			case "socket_timeout":
+				l.SocketTimeout = caddy.Duration(timeout)
+			}
+
+		case "eager_connect":
+			eager := true
+			if d.NextArg() {
+				var err error
+				if eager, err = strconv.ParseBool(d.Val()); err != nil {
+					return d.Errf("invalid eager_connect %q: %v", d.Val(), err)
+				}
+			}
+			l.EagerConnect = &eager
+
+		case "retry_writes", "retry_reads":
+			option, retry := d.Val(), true
+			if d.NextArg() {
+				var err error
+				if retry, err = strconv.ParseBool(d.Val()); err != nil {
+					return d.Errf("invalid %s %q: %v", option, d.Val(), err)
+				}
+			}
+
+			if option == "retry_writes" {
+				l.RetryWrites = &retry
+			} else {
+				l.RetryReads = &retry
+			}
+
+		case "compat":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.Compat = strings.ToLower(d.Val())
+
+		case "compressors":
+			compressors := d.RemainingArgs()
+			if len(compressors) == 0 {
+				return d.ArgErr()
+			}
+
+			l.Compressors = compressors
+
+		case "monitor_servers":
+			l.MonitorServers = true
+			if d.NextArg() {
+				monitor, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return d.Errf("invalid monitor_servers %q: %v", d.Val(), err)
+				}
+				l.MonitorServers = monitor
+			}
+
+		case "app_name":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.AppName = d.Val()
+
+		case "auth_mechanism":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.AuthMechanism = d.Val()
+
+		case "kerberos":
+			k, err := parseKerberos(d)
+			if err != nil {
+				return err
+			}
+			l.Kerberos = k
+
+		case "auth_source":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.AuthSource = d.Val()
+
+		case "cert_file":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.CertFile = d.Val()
+
+		case "key_file":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.KeyFile = d.Val()
+
+		case "timeseries":
+			ts, err := parseTimeSeries(d)
+			if err != nil {
+				return err
+			}
+			l.TimeSeries = ts
+
+		case "capped":
+			l.Capped = true
+			if d.NextArg() {
+				capped, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return d.Errf("invalid capped %q: %v", d.Val(), err)
+				}
+				l.Capped = capped
+			}
+
+		case "max_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			size, err := humanize.ParseBytes(d.Val())
+			if err != nil {
+				return d.Errf("invalid max_size %q: %v", d.Val(), err)
+			}
+			l.MaxSize = int64(size)
+
+		case "max_docs":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			docs, err := strconv.ParseInt(d.Val(), 10, 64)
+			if err != nil {
+				return d.Errf("invalid max_docs %q: %v", d.Val(), err)
+			}
+			l.MaxDocs = docs
+
+		case "retention":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			retention, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid retention %q: %v", d.Val(), err)
+			}
+			l.Retention = caddy.Duration(retention)
+
+		case "expire":
+			if l.Expire == nil {
+				l.Expire = map[string]caddy.Duration{}
+			}
+
+			if err := parseExpire(d, l.Expire); err != nil {
+				return err
+			}
+
+		case "index":
+			fields := d.RemainingArgs()
+			if len(fields) == 0 {
+				return d.ArgErr()
+			}
+
+			l.Indexes = append(l.Indexes, fields)
+
+		case "wildcard_index":
+			l.WildcardIndex = true
+			if d.NextArg() {
+				wildcard, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return d.Errf("invalid wildcard_index %q: %v", d.Val(), err)
+				}
+				l.WildcardIndex = wildcard
+			}
+
+		case "shard_key":
+			l.ShardKey = d.RemainingArgs()
+			if len(l.ShardKey) == 0 {
+				return d.ArgErr()
+			}
+
+		case "route":
+			if l.Routes == nil {
+				l.Routes = map[string]string{}
+			}
+
+			for nesting_route := d.Nesting(); d.NextBlock(nesting_route); {
+				host := strings.ToLower(d.Val())
+
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				l.Routes[host] = d.Val()
+			}
+
+		case "level_route":
+			if l.LevelRoutes == nil {
+				l.LevelRoutes = map[string]string{}
+			}
+
+			for nesting_route := d.Nesting(); d.NextBlock(nesting_route); {
+				level := strings.ToLower(d.Val())
+
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				l.LevelRoutes[level] = d.Val()
+			}
+
+		case "logger_route":
+			if l.LoggerRoutes == nil {
+				l.LoggerRoutes = map[string]LoggerRoute{}
+			}
+
+			for nesting_route := d.Nesting(); d.NextBlock(nesting_route); {
+				name := d.Val()
+
+				args := d.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return d.ArgErr()
+				}
+
+				route := LoggerRoute{Collection: args[0]}
+				if len(args) == 2 {
+					route.Database = args[1]
+				}
+				l.LoggerRoutes[name] = route
+			}
+
+		case "tenant":
+			tenant, err := parseTenant(d)
+			if err != nil {
+				return err
+			}
+			l.Tenant = tenant
+
+		case "two_phase":
+			l.TwoPhase = true
+			if d.NextArg() {
+				twoPhase, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return d.Errf("invalid two_phase %q: %v", d.Val(), err)
+				}
+				l.TwoPhase = twoPhase
+			}
+
+		case "flatten":
+			l.Flatten = true
+			if d.NextArg() {
+				flatten, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return d.Errf("invalid flatten %q: %v", d.Val(), err)
+				}
+				l.Flatten = flatten
+			}
+
+		case "top_level":
+			l.TopLevel = true
+			if d.NextArg() {
+				topLevel, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return d.Errf("invalid top_level %q: %v", d.Val(), err)
+				}
+				l.TopLevel = topLevel
+			}
+
+		case "flatten_separator":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.FlattenSeparator = d.Val()
+
+		case "min_level":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.MinLevel = d.Val()
+
+		case "include_loggers", "exclude_loggers":
+			option, names := d.Val(), d.RemainingArgs()
+			if len(names) == 0 {
+				return d.ArgErr()
+			}
+
+			if option == "include_loggers" {
+				l.IncludeLoggers = append(l.IncludeLoggers, names...)
+			} else {
+				l.ExcludeLoggers = append(l.ExcludeLoggers, names...)
+			}
+
+		case "exclude":
+			paths := d.RemainingArgs()
+			if len(paths) == 0 {
+				return d.ArgErr()
+			}
+			l.Exclude = append(l.Exclude, paths...)
+
+		case "sample_rate", "success_sample_rate":
+			option := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			rate, err := strconv.ParseFloat(d.Val(), 64)
+			if err != nil {
+				return d.Errf("invalid %s %q: %v", option, d.Val(), err)
+			}
+
+			if option == "sample_rate" {
+				l.SampleRate = &rate
+			} else {
+				l.SuccessSampleRate = &rate
+			}
+
+		case "sample":
+			if l.Sample == nil {
+				l.Sample = map[string]float64{}
+			}
+
+			for nesting_sample := d.Nesting(); d.NextBlock(nesting_sample); {
+				status := strings.ToLower(d.Val())
+
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				// allow "200 0.01, 404 0.5" on one line
+				rate, err := strconv.ParseFloat(strings.TrimSuffix(d.Val(), ","), 64)
+				if err != nil {
+					return d.Errf("invalid sample rate %q: %v", d.Val(), err)
+				}
+				l.Sample[status] = rate
+			}
+
+		case "max_writes_per_second":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			limit, err := strconv.ParseFloat(d.Val(), 64)
+			if err != nil {
+				return d.Errf("invalid max_writes_per_second %q: %v", d.Val(), err)
+			}
+			l.MaxWritesPerSecond = limit
+
+		case "redact":
+			r, err := parseRedact(d)
+			if err != nil {
+				return err
+			}
+			l.Redact = r
+
+		case "anonymize_ip":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.AnonymizeIP = d.Val()
+			if d.NextArg() {
+				l.AnonymizeIPSalt = d.Val()
+			}
+
+		case "geoip":
+			g, err := parseGeoIP(d)
+			if err != nil {
+				return err
+			}
+			l.GeoIP = g
+
+		case "parse_user_agent":
+			l.ParseUserAgent = true
+			if d.NextArg() {
+				parse, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return d.Errf("invalid parse_user_agent %q: %v", d.Val(), err)
+				}
+				l.ParseUserAgent = parse
+			}
+
+		case "include_fields":
+			fields := d.RemainingArgs()
+			if len(fields) == 0 {
+				return d.ArgErr()
+			}
+
+			l.IncludeFields = append(l.IncludeFields, fields...)
+
+		case "exclude_fields":
+			fields := d.RemainingArgs()
+			if len(fields) == 0 {
+				return d.ArgErr()
+			}
+
+			l.ExcludeFields = append(l.ExcludeFields, fields...)
+
+		case "ecs":
+			l.ECS = true
+			if d.NextArg() {
+				ecs, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return d.Errf("invalid ecs %q: %v", d.Val(), err)
+				}
+				l.ECS = ecs
+			}
+
+		case "rename":
+			if l.Rename == nil {
+				l.Rename = map[string]string{}
+			}
+
+			for nesting_rename := d.Nesting(); d.NextBlock(nesting_rename); {
+				from := d.Val()
+
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				l.Rename[from] = d.Val()
+			}
+
+		case "coerce":
+			if l.Coerce == nil {
+				l.Coerce = map[string]string{}
+			}
+
+			for nesting_coerce := d.Nesting(); d.NextBlock(nesting_coerce); {
+				field := d.Val()
+
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				l.Coerce[field] = d.Val()
+			}
+
+		case "template":
+			if l.Template == nil {
+				l.Template = map[string]string{}
+			}
+
+			for nesting_template := d.Nesting(); d.NextBlock(nesting_template); {
+				field := d.Val()
+
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				l.Template[field] = d.Val()
+			}
+
+		case "schema_validation":
+			action, err := parseSchemaValidation(d)
+			if err != nil {
+				return err
+			}
+			l.SchemaValidation = action
+
+		case "date_fields":
+			fields := d.RemainingArgs()
+			if len(fields) == 0 {
+				fields = []string{"ts"}
+			}
+
+			l.DateFields = append(l.DateFields, fields...)
+
+		case "tee":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			name := d.Val()
+			wo, err := parseWriterOpener(d)
+			if err != nil {
+				return err
+			}
+			l.TeeRaw = caddyconfig.JSONModuleObject(wo, "output", name, nil)
+
+		case "fallback":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.Fallback = d.Val()
+
+		case "dead_letter":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.DeadLetter = d.Val()
+
+		case "tls_events":
+			l.TLSEvents = defaultTLSEventsCollection
+			if d.NextArg() {
+				l.TLSEvents = d.Val()
+			}
+
+		case "rollup":
+			r, err := parseRollup(d)
+			if err != nil {
+				return err
+			}
+			l.Rollup = r
+
+		case "archive":
+			a, err := parseArchive(d)
+			if err != nil {
+				return err
+			}
+			l.Archive = a
+
+		case "purge":
+			p, err := parsePurge(d)
+			if err != nil {
+				return err
+			}
+			l.Purge = p
+
+		case "gridfs":
+			g, err := parseGridFS(d)
+			if err != nil {
+				return err
+			}
+			l.GridFS = g
+
+		case "max_document_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			size, err := humanize.ParseBytes(d.Val())
+			if err != nil {
+				return d.Errf("invalid max_document_size %q: %v", d.Val(), err)
+			}
+			l.MaxDocumentSize = int64(size)
+
+		case "oversize":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			l.Oversize = d.Val()
+
+		case "encryption":
+			e, err := parseEncryption(d)
+			if err != nil {
+				return err
+			}
+			l.Encryption = e
+		}
+	}
+
+	return nil
+}
+
+func (l *MongoLog) OpenWriter() (io.WriteCloser, error) {
+	writer := &mongoWriter{
+		logger:        l.logger,
+		config:        l,
+		metricsLabel:  l.metricsLabel(),
+		tags:          l.Tags,
+		batchSize:     l.BatchSize,
+		flushInterval: time.Duration(l.FlushInterval),
+		done:          make(chan struct{}),
+		queue:         make(chan []byte, l.QueueSize),
+		overflow:      l.Overflow,
+		blockTimeout:  time.Duration(l.BlockTimeout),
+		maxRetries:    l.MaxRetries,
+		retryInterval: time.Duration(l.RetryInterval),
+		spoolDir:      l.SpoolDir,
+		spoolMaxSize:  l.SpoolMaxSize,
+	}
+
+	if l.MaxWritesPerSecond > 0 {
+		writer.limiter = rate.NewLimiter(rate.Limit(l.MaxWritesPerSecond), max(1, int(l.MaxWritesPerSecond)))
+	}
+
+	// close whatever was opened if a later step fails
+	opened := false
+	defer func() {
+		if opened {
+			return
+		}
+		if writer.tee != nil {
+			writer.tee.Close()
+		}
+		if writer.fallback != nil {
+			writer.fallback.close()
+		}
+		if writer.geoip != nil {
+			writer.geoip.close()
+		}
+	}()
+
+	if l.GeoIP != nil {
+		reader, err := l.GeoIP.open()
+		if err != nil {
+			return nil, err
+		}
+		writer.geoip = reader
+	}
+
+	if writer.spoolDir != "" {
+		if err := writer.openSpool(); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.Fallback != "" {
+		sink, err := openFallback(l.Fallback)
+		if err != nil {
+			return nil, fmt.Errorf("opening fallback %s: %v", l.Fallback, err)
+		}
+		writer.fallback = sink
+	}
+
+	if l.tee != nil {
+		tee, err := l.tee.OpenWriter()
+		if err != nil {
+			return nil, fmt.Errorf("opening tee writer: %v", err)
+		}
+		writer.tee = tee
+	}
+
+	connected := false
+	if l.eagerConnect() {
+		if err := writer.dial(); err != nil {
+			return nil, fmt.Errorf("connecting to mongo database %s: %v", l.Database, err)
+		}
+		connected = true
+	}
+	opened = true
+
+	if writer.spoolDir != "" {
+		writer.wg.Add(1)
+		go writer.spoolLoop()
+	}
+
+	if l.Purge != nil {
+		writer.wg.Add(1)
+		go writer.purgeLoop()
+	}
+
+	if l.Archive != nil {
+		writer.wg.Add(1)
+		go writer.archiveLoop()
+	}
+
+	if l.Rollup != nil {
+		writer.wg.Add(1)
+		go writer.rollupLoop()
+	}
+
+	if l.Alert != nil {
+		writer.wg.Add(1)
+		go writer.alertLoop()
+	}
+
+	writer.wg.Add(1)
+	go func() {
+		defer writer.wg.Done()
+
+		if !connected {
+			if err := writer.Open(l); err != nil {
+				writer.logger.Error("Could not connect to mongo", zap.Error(err))
+			}
+		}
+
+		writer.monitor()
+	}()
+
+	writer.wg.Add(1)
+	go writer.flushLoop()
+
+	writer.startWorkers(l.Workers)
+
+	registerWriter(writer)
+
+	return writer, nil
+}
+
+func (l *MongoLog) Provision(ctx caddy.Context) error {
+	l.logger = ctx.Logger(l)
+	l.storage = ctx.Storage()
+
+	mongoMetrics.init.Do(initMongoMetrics)
+
+	if l.TeeRaw != nil {
+		mod, err := ctx.LoadModule(l, "TeeRaw")
+		if err != nil {
+			return fmt.Errorf("loading tee writer: %v", err)
+		}
+		l.tee = mod.(caddy.WriterOpener)
+	}
+
+	if err := l.inheritDefaults(ctx); err != nil {
+		return err
+	}
+
+	if err := l.resolveCredentials(); err != nil {
+		return err
+	}
+
+	if l.Kerberos != nil {
+		if err := l.Kerberos.provision(); err != nil {
+			return err
+		}
+	}
+
+	l.resolveNames()
+
+	l.exclude = compileGlobs(l.Exclude, "")
+	l.includeLoggers = compileLoggerGlobs(l.IncludeLoggers)
+	l.excludeLoggers = compileLoggerGlobs(l.ExcludeLoggers)
+
+	if l.MinLevel != "" {
+		level, err := zapcore.ParseLevel(l.MinLevel)
+		if err != nil {
+			return fmt.Errorf("invalid min_level %q", l.MinLevel)
+		}
+		l.minLevel = level
+	}
+
+	if l.BatchSize <= 0 {
+		l.BatchSize = defaultBatchSize
+	}
+
+	if l.FlushInterval <= 0 {
+		l.FlushInterval = caddy.Duration(defaultFlushInterval)
+	}
+
+	if l.QueueSize <= 0 {
+		l.QueueSize = defaultQueueSize
+	}
+
+	if l.Workers <= 0 {
+		l.Workers = defaultWorkers
+	}
+
+	if l.Overflow == "" {
+		l.Overflow = overflowBlock
+	}
+
+	if l.MaxRetries <= 0 {
+		l.MaxRetries = defaultMaxRetries
+	}
+
+	if l.RetryInterval <= 0 {
+		l.RetryInterval = caddy.Duration(defaultRetryInterval)
+	}
+
+	if l.Alert != nil {
+		l.Alert.provision()
+	}
+
+	if l.Breaker != nil {
+		l.Breaker.provision()
+	}
+
+	if l.ShutdownTimeout <= 0 {
+		l.ShutdownTimeout = caddy.Duration(defaultShutdownTimeout)
+	}
+
+	if l.SpoolMaxSize <= 0 {
+		l.SpoolMaxSize = defaultSpoolMaxSize
+	}
+
+	if l.Encryption != nil {
+		if err := l.Encryption.provision(); err != nil {
+			return err
+		}
+	}
+
+	if l.GridFS != nil {
+		l.GridFS.provision()
+	}
+
+	if l.MaxDocumentSize <= 0 {
+		l.MaxDocumentSize = defaultMaxDocumentSize
+	}
+
+	if l.Oversize == "" {
+		l.Oversize = oversizeTruncate
+	}
+
+	if l.Purge != nil {
+		if err := l.Purge.provision(); err != nil {
+			return err
+		}
+	}
+
+	if l.Tenant != nil {
+		l.Tenant.provision()
+	}
+
+	if l.Rollup != nil {
+		l.Rollup.provision(l.metadataField)
+	}
+
+	if l.Archive != nil {
+		if err := l.Archive.provision(); err != nil {
+			return err
+		}
+	}
+
+	if l.GeoIP != nil {
+		if err := l.GeoIP.provision(); err != nil {
+			return err
+		}
+	}
+
+	if l.SchemaValidation != "" && l.DeadLetter == "" {
+		l.DeadLetter = l.Collection + deadLetterSuffix
+	}
+
+	if l.FailoverAfter <= 0 {
+		l.FailoverAfter = caddy.Duration(defaultFailoverAfter)
+	}
+
+	if l.FlattenSeparator == "" {
+		l.FlattenSeparator = "_"
+	}
+
+	if l.TLS != nil {
+		cfg, err := l.TLS.build()
+		if err != nil {
+			return err
+		}
+		l.tlsConfig = cfg
+	}
+
+	if err := l.loadClientCert(); err != nil {
+		return err
+	}
+
+	if l.TimeSeries != nil {
+		if l.TimeSeries.TimeField == "" {
+			l.TimeSeries.TimeField = defaultTimeField
+		}
+
+		if l.TimeSeries.MetaField == "" {
+			l.TimeSeries.MetaField = defaultMetaField
+		}
+	}
+
+	return nil
+}
+
+func (l *MongoLog) Validate() error {
+	if l.MongoUri == "" {
+		return fmt.Errorf("NO HOST SET")
+	}
+
+	for i, uri := range l.uris() {
+		if uri == "" {
+			return fmt.Errorf("EMPTY FALLBACK HOST SET")
+		}
+
+		if err := validateURI(uri, l.ValidateDNS); err != nil {
+			if i == 0 {
+				return fmt.Errorf("invalid mongoUri: %v", err)
+			}
+			return fmt.Errorf("invalid fallback URI %d: %v", i, err)
+		}
+	}
+
+	if l.Database == "" {
+		return fmt.Errorf("NO DATABASE SET")
+	}
+
+	if l.Collection == "" {
+		return fmt.Errorf("NO COLLECTION SET")
+	}
+
+	if l.Tags == nil {
+		l.Tags = map[string]string{}
+	}
+
+	switch l.Overflow {
+	case "", overflowBlock, overflowDropOldest, overflowDropNewest:
+	default:
+		return fmt.Errorf("invalid overflow policy %q", l.Overflow)
+	}
+
+	if l.BlockTimeout > 0 && l.Overflow != "" && l.Overflow != overflowBlock {
+		return fmt.Errorf("block_timeout only applies to the %s overflow policy", overflowBlock)
+	}
+
+	if l.WriteConcern != nil {
+		if err := l.WriteConcern.validate(); err != nil {
+			return err
+		}
+	}
+
+	if l.MaxPoolSize > 0 && l.MinPoolSize > l.MaxPoolSize {
+		return fmt.Errorf("min_pool_size cannot exceed max_pool_size")
+	}
+
+	for _, compressor := range l.Compressors {
+		switch compressor {
+		case "zstd", "snappy", "zlib":
+		default:
+			return fmt.Errorf("unsupported compressor %q", compressor)
+		}
+	}
+
+	if err := l.validateAuth(); err != nil {
+		return err
+	}
+
+	if err := l.validateCompat(); err != nil {
+		return err
+	}
+
+	if l.TimeSeries != nil {
+		if err := l.TimeSeries.validate(); err != nil {
+			return err
+		}
+	}
+
+	if l.SampleRate != nil {
+		if err := validateSampleRate("sample_rate", *l.SampleRate); err != nil {
+			return err
+		}
+	}
+
+	if l.SuccessSampleRate != nil {
+		if err := validateSampleRate("success_sample_rate", *l.SuccessSampleRate); err != nil {
+			return err
+		}
+	}
+
+	if err := validateSampleMap(l.Sample); err != nil {
+		return err
+	}
+
+	if l.MaxWritesPerSecond < 0 {
+		return fmt.Errorf("max_writes_per_second cannot be negative")
+	}
+
+	if l.Redact != nil {
+		if err := l.Redact.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := validateAnonymizeIP(l.AnonymizeIP, l.AnonymizeIPSalt); err != nil {
+		return err
+	}
+
+	if l.Encryption != nil {
+		if err := l.Encryption.validate(); err != nil {
+			return err
+		}
+		if err := l.validateEncryptedFields(); err != nil {
+			return err
+		}
+	}
+
+	if l.Capped {
+		if l.TimeSeries != nil {
+			return fmt.Errorf("capped and timeseries collections are mutually exclusive")
+		}
+
+		if l.MaxSize <= 0 {
+			return fmt.Errorf("capped collections require max_size")
+		}
+
+		if l.Retention > 0 {
+			return fmt.Errorf("capped collections cannot have a retention")
+		}
+	}
+
+	for _, fields := range l.Indexes {
+		if len(fields) == 0 {
+			return fmt.Errorf("index must list at least one field")
+		}
+	}
+
+	if len(l.ShardKey) > 0 {
+		if err := l.validateShardKey(); err != nil {
+			return err
+		}
+	}
+
+	if l.Retention < 0 || (l.Retention > 0 && time.Duration(l.Retention) < time.Second) {
+		return fmt.Errorf("retention must be at least one second")
+	}
+
+	if l.Tenant != nil {
+		if err := l.Tenant.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := l.validateExpire(); err != nil {
+		return err
+	}
+
+	if l.Purge != nil {
+		if err := l.Purge.validate(); err != nil {
+			return err
+		}
+	}
+
+	if l.Alert != nil {
+		if err := l.Alert.validate(); err != nil {
+			return err
+		}
+	}
+
+	if l.Breaker != nil {
+		if err := l.Breaker.validate(); err != nil {
+			return err
+		}
+	}
+
+	if l.Fallback != "" {
+		if err := validateFallback(l.Fallback); err != nil {
+			return err
+		}
+	}
+
+	if l.Archive != nil {
+		if err := l.Archive.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := validateCoerce(l.Coerce); err != nil {
+		return err
+	}
+
+	switch l.SchemaValidation {
+	case "", schemaActionError, schemaActionWarn:
+	default:
+		return fmt.Errorf("invalid schema_validation %q", l.SchemaValidation)
+	}
+
+	if l.SchemaValidation != "" && l.TimeSeries != nil {
+		return fmt.Errorf("schema_validation is not supported on timeseries collections")
+	}
+
+	if err := validateOversize(l); err != nil {
+		return err
+	}
+
+	if l.WildcardIndex && l.TimeSeries != nil {
+		return fmt.Errorf("wildcard_index is not supported on timeseries collections")
+	}
+
+	if l.TopLevel && len(l.Template) > 0 {
+		return fmt.Errorf("top_level and template cannot be used together")
+	}
+
+	if l.TwoPhase && l.TimeSeries != nil {
+		return fmt.Errorf("two_phase is not supported on timeseries collections")
+	}
+
+	for name, route := range l.LoggerRoutes {
+		if route.Collection == "" {
+			return fmt.Errorf("logger_route %q has no collection", name)
+		}
+	}
+
+	for level := range l.LevelRoutes {
+		if _, err := zapcore.ParseLevel(level); err != nil {
+			return fmt.Errorf("invalid level_route level %q", level)
+		}
+	}
+
+	return nil
+}
+
+func flatten(m map[string]interface{}, fields map[string]interface{}, prefix string, separator string) map[string]interface{} {
+	for k, v := range m {
+		key := prefix + k
+
+		if v2, ok := v.(map[string]interface{}); ok {
+			flatten(v2, fields, key+separator, separator)
+		} else {
+			fields[key] = v
+		}
+	}
+	return fields
+}
+
+// liftFields copies the fields of an entry into doc and returns those
+// already set there, or named _id or metadata, which are left out.
+func liftFields(doc bson.M, fields map[string]interface{}) map[string]interface{} {
+	var clashing map[string]interface{}
+	for k, v := range fields {
+		if _, taken := doc[k]; taken || k == "_id" || k == "metadata" {
+			if clashing == nil {
+				clashing = map[string]interface{}{}
+			}
+			clashing[k] = v
+			continue
+		}
+		doc[k] = v
+	}
+	return clashing
+}
+
+type mongoWriter struct {
+	logger      *zap.Logger
+	config      *MongoLog
+	measurement string
+	tags        map[string]string
+
+	metricsLabel string
+
+	// index into uris of the endpoint in use, and when it became
+	// unreachable
+	uriIndex  int
+	downSince time.Time
+
+	connMu      sync.RWMutex
+	client      *mongo.Client
+	clientKey   string
+	collections map[target]*mongo.Collection
+	encryption  *mongo.ClientEncryption
+	buckets     map[string]*gridfs.Bucket
+
+	maxRetries    int
+	retryInterval time.Duration
+
+	spoolDir     string
+	spoolMaxSize int64
+	spoolMu      sync.Mutex
+	spoolSize    int64
+	spoolSeq     atomic.Uint64
+
+	mu            sync.Mutex
+	batch         []pendingDoc
+	batchSize     int
+	flushInterval time.Duration
+	done          chan struct{}
+	wg            sync.WaitGroup
+
+	queue        chan []byte
+	closeMu      sync.RWMutex
+	closed       bool
+	overflow     string
+	blockTimeout time.Duration
+	limiter      *rate.Limiter
+	dropped      atomic.Uint64
+	workers      sync.WaitGroup
+
+	tee      io.WriteCloser
+	fallback fallbackSink
+	breaker  breakerState
+	geoip    *geoIPReader
+
+	plainTextOnce sync.Once
+
+	connected    atomic.Bool
+	inserted     atomic.Uint64
+	insertErrors atomic.Uint64
+	lastInsert   atomic.Int64
+
+	insertAttempts atomic.Uint64
+	failedAttempts atomic.Uint64
+}
+
+// Write queues an entry for insertion. Failed inserts happen later and are
+// counted in the status and metrics rather than returned here.
+func (mWrite *mongoWriter) Write(p []byte) (int, error) {
+	// zap reuses the buffer once Write returns
+	entry := make([]byte, len(p))
+	copy(entry, p)
+
+	if !mWrite.enqueue(entry) {
+		return 0, errQueueFull
+	}
+
+	return len(p), nil
+}
+
+// writeTee copies a line to the tee writer, if any.
+func (mWrite *mongoWriter) writeTee(line []byte) {
+	if mWrite.tee == nil {
+		return
+	}
+
+	if isBSON(line) {
+		line = append([]byte(bson.Raw(line).String()), '\n')
+	}
+	if _, err := mWrite.tee.Write(line); err != nil {
+		mWrite.logger.Error("Could not write log entry to tee", zap.Error(err))
+	}
+}
+
+func (mWrite *mongoWriter) process(p []byte) {
+	f, err := mWrite.decodeEntry(p)
+
+	if err == nil && mWrite.config.TLSEvents != "" {
+		mWrite.recordTLSEvent(f, entryTime(f))
+	}
+
+	if err == nil && (mWrite.config.belowMinLevel(f) || mWrite.config.excluded(f) || !mWrite.config.sampled(f)) {
+		return
+	}
+
+	if mWrite.limiter != nil && !mWrite.limiter.Allow() {
+		mWrite.drop(dropRateLimited)
+		return
+	}
+
+	if err != nil {
+		mWrite.logger.Error("Unmarshal failed on log", zap.Error((err)))
+		mWrite.writeTee(p)
+		mWrite.deadLetter(p, err)
+		return
+	}
+
+	now := entryTime(f)
+	t := mWrite.config.target(f, now)
+	tags := mWrite.resolveTags(f)
+
+	correlation := map[string]string{}
+	for _, field := range correlationFields {
+		if v := lookupString(f, field); v != "" {
+			correlation[field] = v
+		}
+	}
+
+	if _, ok := correlation[traceIdField]; !ok {
+		if v := lookupString(f, caddyTraceIdField); v != "" {
+			correlation[traceIdField] = v
+		}
+	}
+
+	if mWrite.geoip != nil {
+		mWrite.geoip.enrich(f)
+	}
+
+	if mWrite.config.ParseUserAgent {
+		parseUserAgent(f)
+	}
+
+	// transforms may drop or move the two_phase flag and the status and
+	// level the expire rules match on
+	started, _ := f[inProgressField].(bool)
+	expireAt, expires := mWrite.config.expireAt(f, now)
+
+	f = mWrite.config.transform(f)
+
+	if mWrite.config.Encryption != nil {
+		mWrite.encryptFields(f)
+	}
+
+	if mWrite.tee != nil {
+		if line, err := json.Marshal(f); err == nil {
+			mWrite.writeTee(append(line, '\n'))
+		}
+	}
+
+	// after transform and encryption, so the bucket holds no more than the
+	// document would
+	if mWrite.config.GridFS != nil {
+		mWrite.offloadBodies(f, t.database)
+	}
+
+	var metadata interface{} = f
+	if mWrite.config.Flatten {
+		metadata = flatten(f, map[string]interface{}{}, "", mWrite.config.FlattenSeparator)
+	}
+
+	tagsField, dateField := "tags", mWrite.config.dateField()
+
+	if ts := mWrite.config.TimeSeries; ts != nil {
+		tagsField = ts.MetaField
+	}
+
+	if len(mWrite.config.Template) > 0 {
+		doc := mWrite.config.renderTemplate(f, metadata, tags, now)
+		if expires {
+			doc[expireAtField] = primitive.NewDateTimeFromTime(expireAt)
+		}
+		if started {
+			doc[inProgressField] = true
+		}
+
+		if raw, ok := mWrite.fitDocument(t, doc); ok {
+			mWrite.add(t, raw)
+		}
+		return
+	}
+
+	doc := bson.M{
+		tagsField: tags,
+		dateField: primitive.NewDateTimeFromTime(now),
+	}
+
+	if !mWrite.config.TopLevel {
+		doc["metadata"] = metadata
+	} else if clashing := liftFields(doc, metadata.(map[string]interface{})); len(clashing) > 0 {
+		doc["metadata"] = clashing
+	}
+
+	for field, v := range correlation {
+		doc[field] = v
+	}
+
+	if expires {
+		doc[expireAtField] = primitive.NewDateTimeFromTime(expireAt)
+	}
+
+	if started {
+		doc[inProgressField] = true
+	}
+
+	if raw, ok := mWrite.fitDocument(t, doc); ok {
+		mWrite.add(t, raw)
+	}
+}
+
+func (mWrite *mongoWriter) Close() error {
+	unregisterWriter(mWrite)
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+
+		mWrite.closeMu.Lock()
+		mWrite.closed = true
+		close(mWrite.queue)
+		mWrite.closeMu.Unlock()
+		mWrite.workers.Wait()
+
+		// no worker looks up addresses anymore
+		if mWrite.geoip != nil {
+			mWrite.geoip.close()
+		}
+
+		close(mWrite.done)
+		mWrite.wg.Wait()
+		mWrite.flush()
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(time.Duration(mWrite.config.ShutdownTimeout)):
+		mWrite.mu.Lock()
+		pending := len(mWrite.batch)
+		mWrite.mu.Unlock()
+
+		// disconnecting fails the inserts still in flight, which are
+		// then spooled if possible
+		mWrite.logger.Warn("Timed out writing log entries on close",
+			zap.Int("queued", len(mWrite.queue)), zap.Int("pending", pending))
+	}
+
+	mWrite.connMu.RLock()
+	client, key, ce := mWrite.client, mWrite.clientKey, mWrite.encryption
+	mWrite.connMu.RUnlock()
+
+	if ce != nil {
+		ce.Close(context.Background())
+	}
+
+	if mWrite.tee != nil {
+		mWrite.tee.Close()
+	}
+
+	if mWrite.fallback != nil {
+		mWrite.fallback.close()
+	}
+
+	if client != nil {
+		releaseClient(key)
+	}
+	return nil
+}
+
+func (mWrite *mongoWriter) Open(i *MongoLog) error {
+	return mWrite.connect()
 }
 
 // Interface guards.
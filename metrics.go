@@ -0,0 +1,93 @@
+package mongo_log
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var mongoMetrics = struct {
+	init          sync.Once
+	inserts       *prometheus.CounterVec
+	insertErrors  *prometheus.CounterVec
+	batchSize     *prometheus.HistogramVec
+	insertLatency *prometheus.HistogramVec
+	queueDepth    *prometheus.GaugeVec
+	dropped       *prometheus.CounterVec
+	breakerOpen   *prometheus.GaugeVec
+
+	serverChanges     *prometheus.CounterVec
+	heartbeatFailures *prometheus.CounterVec
+}{
+	init: sync.Once{},
+}
+
+func initMongoMetrics() {
+	const ns, sub = "caddy", "mongo_log"
+	labels := []string{"writer"}
+
+	mongoMetrics.inserts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "inserts_total",
+		Help:      "Log documents inserted into mongo.",
+	}, labels)
+	mongoMetrics.insertErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "insert_errors_total",
+		Help:      "Failed insert attempts.",
+	}, labels)
+	mongoMetrics.batchSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "batch_size",
+		Help:      "Documents per InsertMany.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, labels)
+	mongoMetrics.insertLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "insert_duration_seconds",
+		Help:      "Latency of successful InsertMany calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, labels)
+	mongoMetrics.queueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "queue_depth",
+		Help:      "Entries waiting in the write queue.",
+	}, labels)
+	mongoMetrics.dropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "dropped_entries_total",
+		Help:      "Log entries dropped before reaching mongo.",
+	}, []string{"writer", "reason"})
+	mongoMetrics.breakerOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "circuit_open",
+		Help:      "Whether the insert circuit breaker is open.",
+	}, labels)
+	mongoMetrics.serverChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "server_changes_total",
+		Help:      "Changes of a mongo server's kind, e.g. to Unknown when it is unreachable.",
+	}, []string{"address", "kind"})
+	mongoMetrics.heartbeatFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "server_heartbeat_failures_total",
+		Help:      "Failed heartbeats to mongo servers.",
+	}, []string{"address"})
+}
+
+// metricsLabel identifies a writer in metrics and the registry as
+// database.collection@hosts, since outputs may share a collection name on
+// different servers.
+func (l *MongoLog) metricsLabel() string {
+	return l.Database + "." + l.Collection + "@" + uriHosts(l.MongoUri)
+}
@@ -0,0 +1,148 @@
+package mongo_log
+
+import (
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const appNamePrefix = "caddy-mongo-logger/"
+
+// defaultAppName identifies the plugin and its version, as built into the
+// Caddy binary, to the server.
+var defaultAppName = sync.OnceValue(func() string {
+	version := "unknown"
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return appNamePrefix + version
+	}
+
+	path := reflect.TypeOf(MongoLog{}).PkgPath()
+	if info.Main.Path == path {
+		version = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			version = dep.Version
+			if dep.Replace != nil {
+				version = dep.Replace.Version
+			}
+		}
+	}
+
+	if version == "" {
+		version = "unknown"
+	}
+	return appNamePrefix + version
+})
+
+// uris lists the configured endpoints, primary first.
+func (l *MongoLog) uris() []string {
+	return append([]string{l.MongoUri}, l.FallbackUris...)
+}
+
+// clientOptions returns the options used when connecting to uri.
+func (l *MongoLog) clientOptions(uri string) *options.ClientOptions {
+	opts := options.Client().ApplyURI(uri)
+
+	// an appName in the URI wins over the default, app_name over both
+	if l.AppName != "" {
+		opts.SetAppName(l.AppName)
+	} else if opts.AppName == nil {
+		opts.SetAppName(defaultAppName())
+	}
+
+	if l.tlsConfig != nil {
+		opts.SetTLSConfig(l.tlsConfig)
+	}
+
+	if l.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(l.MaxPoolSize)
+	}
+
+	if l.MinPoolSize > 0 {
+		opts.SetMinPoolSize(l.MinPoolSize)
+	}
+
+	if l.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(time.Duration(l.ConnectTimeout))
+	}
+
+	if l.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(time.Duration(l.ServerSelectionTimeout))
+	}
+
+	if l.SocketTimeout > 0 {
+		opts.SetSocketTimeout(time.Duration(l.SocketTimeout))
+	}
+
+	if l.MonitorServers {
+		opts.SetServerMonitor(l.serverMonitor())
+	}
+
+	if len(l.Compressors) > 0 {
+		opts.SetCompressors(l.Compressors)
+	}
+
+	if cred := l.credential(); cred != nil {
+		opts.SetAuth(*cred)
+	}
+
+	if l.RetryWrites != nil {
+		opts.SetRetryWrites(*l.RetryWrites)
+	} else if l.Compat != "" {
+		opts.SetRetryWrites(false)
+	}
+
+	if l.RetryReads != nil {
+		opts.SetRetryReads(*l.RetryReads)
+	}
+
+	return opts
+}
+
+// collectionOptions returns the options used when opening the log collection.
+func (l *MongoLog) collectionOptions() *options.CollectionOptions {
+	opts := options.Collection()
+
+	if l.WriteConcern != nil {
+		opts.SetWriteConcern(l.WriteConcern.writeConcern())
+	} else if wc := l.compatWriteConcern(); wc != nil {
+		opts.SetWriteConcern(wc)
+	}
+
+	return opts
+}
+
+// dialTimeout bounds connecting to mongo and preparing the collection.
+func (l *MongoLog) dialTimeout() time.Duration {
+	if l.ConnectTimeout > 0 {
+		return time.Duration(l.ConnectTimeout)
+	}
+	return connectTimeout
+}
+
+func (l *MongoLog) eagerConnect() bool {
+	return l.EagerConnect == nil || *l.EagerConnect
+}
+
+// dateField is the document field holding the entry time.
+func (l *MongoLog) dateField() string {
+	if l.TimeSeries != nil {
+		return l.TimeSeries.TimeField
+	}
+	return "date"
+}
+
+// metadataField is the document path of an entry field, e.g.
+// metadata.status, or just status with top_level.
+func (l *MongoLog) metadataField(field string) string {
+	if l.TopLevel {
+		return field
+	}
+	return "metadata." + field
+}
@@ -0,0 +1,210 @@
+package mongo_log
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+// What to do with a document larger than max_document_size.
+const (
+	oversizeTruncate   = "truncate"
+	oversizeDropBodies = "drop_bodies"
+	oversizeGridFS     = "gridfs"
+	oversizeReject     = "reject"
+
+	// the server rejects larger documents
+	defaultMaxDocumentSize = 16 << 20
+
+	// truncated strings end with this marker
+	truncatedMarker = "...[truncated]"
+)
+
+// bodyFields are the captured body fields drop_bodies removes.
+var bodyFields = []string{"req_body", "resp_body"}
+
+func validateOversize(l *MongoLog) error {
+	switch l.Oversize {
+	case "", oversizeTruncate, oversizeDropBodies, oversizeReject:
+	case oversizeGridFS:
+		if l.GridFS == nil {
+			return fmt.Errorf("oversize %s requires a gridfs block", oversizeGridFS)
+		}
+	default:
+		return fmt.Errorf("invalid oversize strategy %q", l.Oversize)
+	}
+
+	if l.MaxDocumentSize < 0 || l.MaxDocumentSize > defaultMaxDocumentSize {
+		return fmt.Errorf("max_document_size must be at most %d bytes", defaultMaxDocumentSize)
+	}
+	return nil
+}
+
+// fitDocument encodes doc and, if it exceeds MaxDocumentSize, applies the
+// Oversize strategy. It returns the encoded document, or false if it was
+// dead-lettered instead.
+func (mWrite *mongoWriter) fitDocument(t target, doc bson.M) (bson.Raw, bool) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		mWrite.rejected(t, doc, err)
+		return nil, false
+	}
+
+	limit := int(mWrite.config.MaxDocumentSize)
+	if len(raw) <= limit {
+		return raw, true
+	}
+
+	size := len(raw)
+	strategy := mWrite.config.Oversize
+
+	switch strategy {
+	case oversizeReject:
+		mWrite.rejected(t, doc, fmt.Errorf("document of %d bytes exceeds max_document_size", size))
+		return nil, false
+
+	case oversizeGridFS:
+		stub, divertErr := mWrite.divertDocument(t, doc, raw)
+		if divertErr != nil {
+			mWrite.logger.Error("Could not store oversized log document in GridFS, truncating it", zap.Error(divertErr))
+			break
+		}
+
+		// top-level strings, e.g. bodies with top_level, stay in the stub
+		doc = stub
+		if raw, err = bson.Marshal(doc); err == nil && len(raw) <= limit {
+			return raw, true
+		}
+
+	case oversizeDropBodies:
+		for _, field := range bodyFields {
+			deleteField(doc, field)
+		}
+		doc["oversize"] = bson.M{"size": size, "dropped": bodyFields}
+
+		if raw, err = bson.Marshal(doc); err == nil && len(raw) <= limit {
+			return raw, true
+		}
+	}
+
+	// truncate the largest strings until the document fits
+	for len(raw) > limit {
+		s, set := largestString(doc)
+		if set == nil || len(s) <= len(truncatedMarker) {
+			break
+		}
+
+		keep := max(0, len(s)-(len(raw)-limit)-len(truncatedMarker))
+		// cutting inside a rune would make the string invalid UTF-8
+		for keep > 0 && keep < len(s) && !utf8.RuneStart(s[keep]) {
+			keep--
+		}
+		set(s[:keep] + truncatedMarker)
+
+		oversize, _ := doc["oversize"].(bson.M)
+		if oversize == nil {
+			oversize = bson.M{"size": size}
+			doc["oversize"] = oversize
+		}
+		oversize["truncated"] = true
+
+		if raw, err = bson.Marshal(doc); err != nil {
+			break
+		}
+	}
+
+	if err != nil || len(raw) > limit {
+		mWrite.rejected(t, doc, fmt.Errorf("document of %d bytes exceeds max_document_size", size))
+		return nil, false
+	}
+
+	mWrite.logger.Warn("Truncated oversized log document", zap.Int("size", size), zap.Int("limit", limit))
+	return raw, true
+}
+
+// divertDocument uploads an encoded document to GridFS and returns a stub
+// holding its top-level fields other than sub-documents, and a reference.
+// The stub may still exceed the size limit.
+func (mWrite *mongoWriter) divertDocument(t target, doc bson.M, raw bson.Raw) (bson.M, error) {
+	bucket, err := mWrite.bucketFor(t.database)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := fmt.Sprintf("%d-document.bson", time.Now().UnixNano())
+	id, err := bucket.UploadFromStream(filename, bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	stub := bson.M{}
+	for field, v := range doc {
+		switch v.(type) {
+		case map[string]interface{}, bson.M:
+		default:
+			stub[field] = v
+		}
+	}
+	stub["oversize"] = bson.M{"size": len(raw), "gridfs_id": id}
+
+	return stub, nil
+}
+
+// deleteField removes field from doc and every sub-document.
+func deleteField(v interface{}, field string) {
+	switch v := v.(type) {
+	case bson.M:
+		deleteField(map[string]interface{}(v), field)
+	case map[string]interface{}:
+		delete(v, field)
+		for _, child := range v {
+			deleteField(child, field)
+		}
+	case []interface{}:
+		for _, child := range v {
+			deleteField(child, field)
+		}
+	}
+}
+
+// largestString finds the longest string in v and returns it with a
+// function replacing it, or a nil function if v holds no strings.
+func largestString(v interface{}) (string, func(string)) {
+	var largest string
+	var set func(string)
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case bson.M:
+			walk(map[string]interface{}(v))
+		case map[string]interface{}:
+			for key, child := range v {
+				if s, ok := child.(string); ok {
+					if len(s) > len(largest) {
+						largest, set = s, func(s string) { v[key] = s }
+					}
+					continue
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for i, child := range v {
+				if s, ok := child.(string); ok {
+					if len(s) > len(largest) {
+						largest, set = s, func(s string) { v[i] = s }
+					}
+					continue
+				}
+				walk(child)
+			}
+		}
+	}
+	walk(v)
+
+	return largest, set
+}
@@ -0,0 +1,142 @@
+package mongo_log
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFitDocument(t *testing.T) {
+	const limit = 512
+
+	tests := []struct {
+		name     string
+		oversize string
+		doc      bson.M
+		wantOK   bool
+		// fields, as dot paths, expected absent from the stored document
+		wantDropped []string
+		wantMarker  string
+	}{
+		{
+			name:   "fits",
+			doc:    bson.M{"msg": "handled request", "metadata": bson.M{"status": 200}},
+			wantOK: true,
+		},
+		{
+			name:       "truncate",
+			doc:        bson.M{"msg": "handled request", "metadata": bson.M{"req_body": strings.Repeat("a", 2000)}},
+			wantOK:     true,
+			wantMarker: "metadata.req_body",
+		},
+		{
+			name:       "truncate on a rune boundary",
+			doc:        bson.M{"metadata": bson.M{"resp_body": strings.Repeat("é", 1000)}},
+			wantOK:     true,
+			wantMarker: "metadata.resp_body",
+		},
+		{
+			name:        "drop_bodies",
+			oversize:    oversizeDropBodies,
+			doc:         bson.M{"msg": "handled request", "metadata": bson.M{"req_body": strings.Repeat("a", 1000), "resp_body": strings.Repeat("b", 1000), "status": 200}},
+			wantOK:      true,
+			wantDropped: []string{"metadata.req_body", "metadata.resp_body"},
+		},
+		{
+			name:       "drop_bodies then truncate",
+			oversize:   oversizeDropBodies,
+			doc:        bson.M{"metadata": bson.M{"req_body": strings.Repeat("a", 1000), "uri": strings.Repeat("/x", 500)}},
+			wantOK:     true,
+			wantMarker: "metadata.uri",
+		},
+		{
+			name:     "reject",
+			oversize: oversizeReject,
+			doc:      bson.M{"metadata": bson.M{"req_body": strings.Repeat("a", 2000)}},
+		},
+		{
+			name: "too many small fields to truncate",
+			doc: func() bson.M {
+				doc := bson.M{}
+				for i := 0; i < 200; i++ {
+					doc[fmt.Sprintf("field%03d", i)] = int64(i)
+				}
+				return doc
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mWrite := newTestWriter(&MongoLog{MaxDocumentSize: limit, Oversize: tt.oversize, DeadLetter: "caddy_dead_letter"})
+			main := mWrite.config.target(nil, testTime)
+
+			raw, ok := mWrite.fitDocument(main, tt.doc)
+			if ok != tt.wantOK {
+				t.Fatalf("fitDocument ok = %v, want %v", ok, tt.wantOK)
+			}
+
+			if !ok {
+				if len(mWrite.batch) != 1 || mWrite.batch[0].target.collection != "caddy_dead_letter" {
+					t.Errorf("rejected document not dead-lettered: %+v", mWrite.batch)
+				}
+				return
+			}
+
+			if len(raw) > limit {
+				t.Errorf("stored document is %d bytes, limit %d", len(raw), limit)
+			}
+
+			stored, err := mWrite.decodeEntry(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, path := range tt.wantDropped {
+				if _, found := lookup(stored, splitPath(path)...); found {
+					t.Errorf("%s still stored", path)
+				}
+			}
+
+			if tt.wantMarker == "" {
+				if tt.wantDropped == nil && stored["oversize"] != nil {
+					t.Errorf("document within the limit marked oversize: %v", stored["oversize"])
+				}
+				return
+			}
+
+			v, _ := lookup(stored, splitPath(tt.wantMarker)...)
+			s, _ := v.(string)
+			if !strings.HasSuffix(s, truncatedMarker) {
+				t.Errorf("%s = %q, want it to end in %q", tt.wantMarker, s, truncatedMarker)
+			}
+			if !utf8.ValidString(s) {
+				t.Errorf("%s is not valid UTF-8 after truncation", tt.wantMarker)
+			}
+			if truncated, _ := lookup(stored, "oversize", "truncated"); truncated != true {
+				t.Errorf("oversize = %v, want truncated", stored["oversize"])
+			}
+		})
+	}
+}
+
+func TestDeleteField(t *testing.T) {
+	doc := bson.M{
+		"req_body": "top",
+		"metadata": bson.M{"req_body": "nested", "status": 200},
+		"parts":    []interface{}{map[string]interface{}{"req_body": "in array", "n": 1}},
+	}
+	want := bson.M{
+		"metadata": bson.M{"status": 200},
+		"parts":    []interface{}{map[string]interface{}{"n": 1}},
+	}
+
+	deleteField(doc, "req_body")
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("deleteField = %v, want %v", doc, want)
+	}
+}
@@ -0,0 +1,234 @@
+package mongo_log
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPurgeSchedule  = "@hourly"
+	defaultPurgeBatchSize = 1000
+	defaultPurgePause     = 100 * time.Millisecond
+)
+
+// Purge periodically deletes documents older than MaxAge, for collections
+// that cannot expire documents through a TTL index. Deletes run in batches
+// of BatchSize with a Pause in between to limit the load on the server.
+type Purge struct {
+	// A cron expression such as "0 3 * * *", or a descriptor like @daily
+	// or "@every 6h".
+	Schedule  string         `json:"schedule,omitempty"`
+	MaxAge    caddy.Duration `json:"max_age,omitempty"`
+	BatchSize int            `json:"batch_size,omitempty"`
+	Pause     caddy.Duration `json:"pause,omitempty"`
+
+	schedule cron.Schedule
+}
+
+func parsePurge(d *caddyfile.Dispenser) (*Purge, error) {
+	p := &Purge{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "schedule":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			p.Schedule = d.Val()
+
+		case "max_age":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			age, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid purge max_age %q: %v", d.Val(), err)
+			}
+			p.MaxAge = caddy.Duration(age)
+
+		case "batch_size":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			size, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid purge batch_size %q: %v", d.Val(), err)
+			}
+			p.BatchSize = size
+
+		case "pause":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			pause, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid purge pause %q: %v", d.Val(), err)
+			}
+			p.Pause = caddy.Duration(pause)
+
+		default:
+			return nil, d.Errf("unrecognized purge option %q", d.Val())
+		}
+	}
+
+	return p, nil
+}
+
+func (p *Purge) provision() error {
+	if p.Schedule == "" {
+		p.Schedule = defaultPurgeSchedule
+	}
+
+	if p.BatchSize <= 0 {
+		p.BatchSize = defaultPurgeBatchSize
+	}
+
+	if p.Pause <= 0 {
+		p.Pause = caddy.Duration(defaultPurgePause)
+	}
+
+	schedule, err := cron.ParseStandard(p.Schedule)
+	if err != nil {
+		return fmt.Errorf("invalid purge schedule %q: %v", p.Schedule, err)
+	}
+	p.schedule = schedule
+
+	return nil
+}
+
+func (p *Purge) validate() error {
+	if p.MaxAge <= 0 {
+		return fmt.Errorf("purge requires a max_age")
+	}
+	return nil
+}
+
+// purgeLoop runs the purge on its schedule until done is closed.
+func (mWrite *mongoWriter) purgeLoop() {
+	defer mWrite.wg.Done()
+
+	p := mWrite.config.Purge
+	for {
+		timer := time.NewTimer(time.Until(p.schedule.Next(time.Now())))
+
+		select {
+		case <-timer.C:
+			mWrite.purge()
+		case <-mWrite.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// purge deletes expired documents from the default collection and every
+// collection this writer has written to.
+func (mWrite *mongoWriter) purge() {
+	p := mWrite.config.Purge
+	cutoff := primitive.NewDateTimeFromTime(time.Now().Add(-time.Duration(p.MaxAge)))
+
+	for _, t := range mWrite.knownTargets() {
+		collection, err := mWrite.collectionFor(t)
+		if err != nil {
+			mWrite.logger.Error("Could not purge log collection", zap.String("collection", t.collection), zap.Error(err))
+			continue
+		}
+
+		deleted, err := mWrite.purgeCollection(collection, cutoff)
+		if err != nil {
+			mWrite.logger.Error("Could not purge log collection", zap.String("collection", t.collection), zap.Error(err))
+		}
+		if deleted > 0 {
+			mWrite.logger.Info("Purged expired log documents", zap.String("collection", t.collection), zap.Int64("count", deleted))
+		}
+	}
+}
+
+// purgeCollection deletes documents whose date is before cutoff, one batch
+// of ids at a time.
+func (mWrite *mongoWriter) purgeCollection(collection *mongo.Collection, cutoff primitive.DateTime) (int64, error) {
+	p := mWrite.config.Purge
+	filter := bson.M{mWrite.config.dateField(): bson.M{"$lt": cutoff}}
+	find := options.Find().
+		SetProjection(bson.M{"_id": 1}).
+		SetLimit(int64(p.BatchSize))
+
+	var total int64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+		deleted, n, err := deleteBatch(ctx, collection, filter, find)
+		cancel()
+
+		total += deleted
+		if err != nil || n < p.BatchSize {
+			return total, err
+		}
+
+		if !mWrite.sleep(time.Duration(p.Pause)) {
+			return total, nil
+		}
+	}
+}
+
+// deleteBatch deletes the documents found by one limited query and returns
+// how many were deleted and found.
+func deleteBatch(ctx context.Context, collection *mongo.Collection, filter bson.M, find *options.FindOptions) (int64, int, error) {
+	cursor, err := collection.Find(ctx, filter, find)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var docs []struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return 0, 0, err
+	}
+
+	if len(docs) == 0 {
+		return 0, 0, nil
+	}
+
+	ids := make(bson.A, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+
+	res, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, len(docs), err
+	}
+	return res.DeletedCount, len(docs), nil
+}
+
+// knownTargets returns the default target and every target this writer has
+// opened a collection for.
+func (mWrite *mongoWriter) knownTargets() []target {
+	def := mWrite.config.target(nil, time.Now())
+	targets := []target{def}
+
+	mWrite.connMu.RLock()
+	for t := range mWrite.collections {
+		if t != def {
+			targets = append(targets, t)
+		}
+	}
+	mWrite.connMu.RUnlock()
+
+	return targets
+}
@@ -0,0 +1,129 @@
+package mongo_log
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	overflowBlock      = "block"
+	overflowDropOldest = "drop_oldest"
+	overflowDropNewest = "drop_newest"
+
+	defaultQueueSize = 10000
+	defaultWorkers   = 2
+)
+
+// Reasons an entry is dropped, as reported in metrics.
+const (
+	dropQueueFull   = "queue_full"
+	dropRateLimited = "rate_limited"
+	dropRejected    = "rejected"
+	dropClosed      = "closed"
+)
+
+func (mWrite *mongoWriter) drop(reason string) {
+	mWrite.dropped.Add(1)
+	mongoMetrics.dropped.WithLabelValues(mWrite.metricsLabel, reason).Inc()
+}
+
+// errQueueFull is returned by Write when the entry is dropped by the
+// drop_newest overflow policy or after waiting block_timeout.
+var errQueueFull = errors.New("mongo_log queue is full, entry dropped")
+
+// addDocument batches a document built outside the log pipeline, such as
+// a Caddy event, for t. It reports false once the writer is closed.
+func (mWrite *mongoWriter) addDocument(t target, doc interface{}) bool {
+	mWrite.closeMu.RLock()
+	if mWrite.closed {
+		mWrite.closeMu.RUnlock()
+		mWrite.drop(dropClosed)
+		return false
+	}
+
+	full := mWrite.appendDoc(t, doc)
+	mWrite.closeMu.RUnlock()
+
+	// flush without the lock, so Close is not held up by its retries
+	if full {
+		mWrite.flush()
+	}
+	return true
+}
+
+// enqueue hands an entry to the workers, applying the overflow policy
+// when the queue is full. It reports whether the entry was queued.
+func (mWrite *mongoWriter) enqueue(entry []byte) bool {
+	// entries can arrive after Close, e.g. from handlers holding the writer
+	mWrite.closeMu.RLock()
+	defer mWrite.closeMu.RUnlock()
+
+	if mWrite.closed {
+		mWrite.drop(dropClosed)
+		return false
+	}
+
+	switch mWrite.overflow {
+	case overflowDropNewest:
+		select {
+		case mWrite.queue <- entry:
+		default:
+			mWrite.drop(dropQueueFull)
+			return false
+		}
+
+	case overflowDropOldest:
+		for {
+			select {
+			case mWrite.queue <- entry:
+				return true
+			default:
+			}
+
+			select {
+			case <-mWrite.queue:
+				mWrite.drop(dropQueueFull)
+			default:
+			}
+		}
+
+	default:
+		if mWrite.blockTimeout <= 0 {
+			mWrite.queue <- entry
+			break
+		}
+
+		select {
+		case mWrite.queue <- entry:
+			return true
+		default:
+		}
+
+		timer := time.NewTimer(mWrite.blockTimeout)
+		defer timer.Stop()
+
+		select {
+		case mWrite.queue <- entry:
+		case <-timer.C:
+			mWrite.drop(dropQueueFull)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (mWrite *mongoWriter) startWorkers(n int) {
+	for i := 0; i < n; i++ {
+		mWrite.workers.Add(1)
+		go mWrite.worker()
+	}
+}
+
+func (mWrite *mongoWriter) worker() {
+	defer mWrite.workers.Done()
+
+	for entry := range mWrite.queue {
+		mWrite.process(entry)
+	}
+}
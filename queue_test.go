@@ -0,0 +1,77 @@
+package mongo_log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEnqueue(t *testing.T) {
+	tests := []struct {
+		name         string
+		overflow     string
+		blockTimeout time.Duration
+		full         bool
+		closed       bool
+		wantQueued   bool
+		wantQueue    []string
+		wantDropped  uint64
+	}{
+		{name: "room", overflow: overflowDropNewest, wantQueued: true, wantQueue: []string{"old", "new"}},
+		{name: "drop_newest", overflow: overflowDropNewest, full: true, wantQueue: []string{"old", "older"}, wantDropped: 1},
+		{name: "drop_oldest", overflow: overflowDropOldest, full: true, wantQueued: true, wantQueue: []string{"older", "new"}, wantDropped: 1},
+		{name: "block timeout", overflow: overflowBlock, blockTimeout: 10 * time.Millisecond, full: true, wantQueue: []string{"old", "older"}, wantDropped: 1},
+		{name: "block with room", overflow: overflowBlock, blockTimeout: 10 * time.Millisecond, wantQueued: true, wantQueue: []string{"old", "new"}},
+		{name: "closed", overflow: overflowDropOldest, closed: true, wantQueue: []string{"old"}, wantDropped: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mWrite := newTestWriter(&MongoLog{})
+			mWrite.overflow = tt.overflow
+			mWrite.blockTimeout = tt.blockTimeout
+			mWrite.closed = tt.closed
+
+			mWrite.queue = make(chan []byte, 2)
+			mWrite.queue <- []byte("old")
+			if tt.full {
+				mWrite.queue <- []byte("older")
+			}
+
+			if queued := mWrite.enqueue([]byte("new")); queued != tt.wantQueued {
+				t.Errorf("enqueue = %v, want %v", queued, tt.wantQueued)
+			}
+
+			close(mWrite.queue)
+			var queue []string
+			for entry := range mWrite.queue {
+				queue = append(queue, string(entry))
+			}
+
+			if len(queue) != len(tt.wantQueue) {
+				t.Fatalf("queue = %q, want %q", queue, tt.wantQueue)
+			}
+			for i := range queue {
+				if queue[i] != tt.wantQueue[i] {
+					t.Errorf("queue = %q, want %q", queue, tt.wantQueue)
+					break
+				}
+			}
+
+			if dropped := mWrite.dropped.Load(); dropped != tt.wantDropped {
+				t.Errorf("dropped = %d, want %d", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestAddDocumentClosed(t *testing.T) {
+	mWrite := newTestWriter(&MongoLog{})
+	mWrite.closed = true
+
+	if mWrite.addDocument(mWrite.config.target(nil, testTime), map[string]interface{}{"msg": "event"}) {
+		t.Error("addDocument after Close = true, want false")
+	}
+	if len(mWrite.batch) != 0 || mWrite.dropped.Load() != 1 {
+		t.Errorf("after Close: %d documents batched and %d dropped, want 0 and 1", len(mWrite.batch), mWrite.dropped.Load())
+	}
+}
@@ -0,0 +1,204 @@
+package mongo_log
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMaxRetries    = 5
+	defaultRetryInterval = time.Second
+
+	maxRetryInterval    = time.Minute
+	connectTimeout      = 10 * time.Second
+	healthCheckInterval = 10 * time.Second
+
+	defaultFailoverAfter = 30 * time.Second
+)
+
+var errNotConnected = errors.New("not connected to mongo")
+
+// backoff returns the wait before the given retry attempt: the base interval
+// doubled per attempt, capped at maxRetryInterval, with equal jitter.
+func backoff(base time.Duration, attempt int) time.Duration {
+	wait := base
+	for i := 0; i < attempt && wait < maxRetryInterval; i++ {
+		wait *= 2
+	}
+	if wait > maxRetryInterval {
+		wait = maxRetryInterval
+	}
+
+	half := wait / 2
+	return half + rand.N(half+1)
+}
+
+// sleep waits for d and reports false if the writer was closed meanwhile.
+func (mWrite *mongoWriter) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-mWrite.done:
+		return false
+	}
+}
+
+// connect dials mongo, retrying with exponential backoff up to maxRetries.
+func (mWrite *mongoWriter) connect() error {
+	var err error
+	for attempt := 0; attempt <= mWrite.maxRetries; attempt++ {
+		if attempt > 0 {
+			wait := backoff(mWrite.retryInterval, attempt-1)
+			mWrite.logger.Warn("Retrying mongo connection", zap.Int("attempt", attempt), zap.Duration("wait", wait), zap.Error(err))
+
+			if !mWrite.sleep(wait) {
+				return err
+			}
+		}
+
+		mWrite.maybeFailover()
+
+		if err = mWrite.dial(); err == nil {
+			mWrite.downSince = time.Time{}
+			return nil
+		}
+
+		if mWrite.downSince.IsZero() {
+			mWrite.downSince = time.Now()
+		}
+	}
+
+	return err
+}
+
+// maybeFailover moves on to the next configured endpoint once the current
+// one has been unreachable for failover_after.
+func (mWrite *mongoWriter) maybeFailover() {
+	uris := mWrite.config.uris()
+	if len(uris) < 2 || mWrite.downSince.IsZero() {
+		return
+	}
+
+	if time.Since(mWrite.downSince) < time.Duration(mWrite.config.FailoverAfter) {
+		return
+	}
+
+	mWrite.uriIndex = (mWrite.uriIndex + 1) % len(uris)
+	mWrite.downSince = time.Now()
+
+	mWrite.logger.Warn("Failing over to next mongo endpoint", zap.Int("endpoint", mWrite.uriIndex))
+}
+
+// dial acquires and verifies a client, replacing the current one.
+func (mWrite *mongoWriter) dial() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mWrite.config.dialTimeout())
+	defer cancel()
+
+	con, key, err := mWrite.config.acquireClient(ctx, mWrite.config.uris()[mWrite.uriIndex])
+	if err != nil {
+		return err
+	}
+
+	if err := con.Ping(ctx, nil); err != nil {
+		releaseClient(key)
+		return err
+	}
+
+	// open the current collection up front so a bad collection config
+	// fails the connect instead of every insert
+	t := mWrite.config.target(nil, time.Now())
+	collection, err := mWrite.config.openCollection(ctx, con, t)
+	if err != nil {
+		releaseClient(key)
+		return err
+	}
+
+	if err := mWrite.config.ensureIndexes(ctx, collection); err != nil {
+		mWrite.logger.Error("Could not create indexes on log collection", zap.String("collection", t.collection), zap.Error(err))
+	}
+
+	if len(mWrite.config.ShardKey) > 0 {
+		if err := mWrite.config.ensureSharded(ctx, collection); err != nil {
+			mWrite.logger.Error("Could not shard log collection", zap.String("collection", t.collection), zap.Error(err))
+		}
+	}
+
+	var ce *mongo.ClientEncryption
+	if mWrite.config.Encryption != nil {
+		if ce, err = mWrite.config.Encryption.open(ctx, con); err != nil {
+			mWrite.logger.Error("Could not set up field encryption, encrypted fields will be dropped", zap.Error(err))
+		}
+	}
+
+	mWrite.connMu.Lock()
+	old, oldKey, oldCE := mWrite.client, mWrite.clientKey, mWrite.encryption
+	mWrite.client = con
+	mWrite.clientKey = key
+	mWrite.collections = map[target]*mongo.Collection{t: collection}
+	mWrite.encryption = ce
+	mWrite.buckets = nil
+	mWrite.connMu.Unlock()
+
+	mWrite.connected.Store(true)
+
+	if oldCE != nil {
+		oldCE.Close(context.Background())
+	}
+
+	if old != nil {
+		releaseClient(oldKey)
+	}
+
+	return nil
+}
+
+func (mWrite *mongoWriter) ping() error {
+	mWrite.connMu.RLock()
+	client := mWrite.client
+	mWrite.connMu.RUnlock()
+
+	if client == nil {
+		return errNotConnected
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	return client.Ping(ctx, nil)
+}
+
+// monitor pings the server periodically and reconnects when it stops
+// responding, until the writer is closed.
+func (mWrite *mongoWriter) monitor() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mWrite.done:
+			return
+		case <-ticker.C:
+			err := mWrite.ping()
+			if err == nil {
+				continue
+			}
+			mWrite.connected.Store(false)
+			if mWrite.downSince.IsZero() {
+				mWrite.downSince = time.Now()
+			}
+
+			mWrite.logger.Warn("Mongo connection lost, reconnecting", zap.Error(err))
+			if err := mWrite.connect(); err != nil {
+				mWrite.logger.Error("Could not reconnect to mongo", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package mongo_log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		base    time.Duration
+		attempt int
+		want    time.Duration
+	}{
+		{base: time.Second, attempt: 0, want: time.Second},
+		{base: time.Second, attempt: 1, want: 2 * time.Second},
+		{base: time.Second, attempt: 4, want: 16 * time.Second},
+		{base: time.Second, attempt: 6, want: maxRetryInterval},
+		{base: time.Second, attempt: 100, want: maxRetryInterval},
+		{base: 5 * time.Minute, attempt: 0, want: maxRetryInterval},
+	}
+
+	for _, tt := range tests {
+		// jitter keeps each wait between half and all of the full one
+		for i := 0; i < 100; i++ {
+			got := backoff(tt.base, tt.attempt)
+			if got < tt.want/2 || got > tt.want {
+				t.Fatalf("backoff(%v, %d) = %v, want between %v and %v", tt.base, tt.attempt, got, tt.want/2, tt.want)
+			}
+		}
+	}
+}
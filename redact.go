@@ -0,0 +1,195 @@
+package mongo_log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+const (
+	redactReplace = "replace"
+	redactHash    = "hash"
+
+	redacted = "[REDACTED]"
+)
+
+// Redact hides sensitive header, cookie and query parameter values before
+// entries are stored.
+type Redact struct {
+	// Applies to both request and response headers.
+	Headers []string `json:"headers,omitempty"`
+	Cookies []string `json:"cookies,omitempty"`
+	Query   []string `json:"query,omitempty"`
+
+	// "replace" (default) stores [REDACTED]; "hash" stores a salted
+	// SHA-256 so equal values can still be correlated.
+	Mode string `json:"mode,omitempty"`
+	Salt string `json:"salt,omitempty"`
+}
+
+func parseRedact(d *caddyfile.Dispenser) (*Redact, error) {
+	r := &Redact{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "headers":
+			r.Headers = append(r.Headers, d.RemainingArgs()...)
+
+		case "cookies":
+			r.Cookies = append(r.Cookies, d.RemainingArgs()...)
+
+		case "query":
+			r.Query = append(r.Query, d.RemainingArgs()...)
+
+		case "mode":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			r.Mode = d.Val()
+
+		case "salt":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			r.Salt = d.Val()
+
+		default:
+			return nil, d.Errf("unrecognized redact option %q", d.Val())
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Redact) validate() error {
+	switch r.Mode {
+	case "", redactReplace, redactHash:
+		return nil
+	default:
+		return fmt.Errorf("invalid redact mode %q", r.Mode)
+	}
+}
+
+func (r *Redact) value(v string) string {
+	if r.Mode != redactHash {
+		return redacted
+	}
+
+	sum := sha256.Sum256([]byte(r.Salt + v))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// apply redacts the configured values of an access log entry in place.
+func (r *Redact) apply(entry map[string]interface{}) {
+	request, _ := entry["request"].(map[string]interface{})
+	reqHeaders, _ := lookup(entry, "request", "headers")
+	respHeaders, _ := entry["resp_headers"].(map[string]interface{})
+
+	if headers, ok := reqHeaders.(map[string]interface{}); ok {
+		r.redactHeaders(headers)
+		r.redactCookies(headers, "Cookie")
+	}
+
+	if respHeaders != nil {
+		r.redactHeaders(respHeaders)
+		r.redactCookies(respHeaders, "Set-Cookie")
+	}
+
+	if uri, ok := request["uri"].(string); ok && len(r.Query) > 0 {
+		request["uri"] = r.redactURI(uri)
+	}
+}
+
+func (r *Redact) redactHeaders(headers map[string]interface{}) {
+	for _, name := range r.Headers {
+		values, ok := headers[http.CanonicalHeaderKey(name)].([]interface{})
+		if !ok {
+			continue
+		}
+
+		for i, v := range values {
+			if s, ok := v.(string); ok {
+				values[i] = r.value(s)
+			}
+		}
+	}
+}
+
+// redactCookies rewrites "name=value" pairs in Cookie or Set-Cookie values.
+func (r *Redact) redactCookies(headers map[string]interface{}, header string) {
+	if len(r.Cookies) == 0 {
+		return
+	}
+
+	values, ok := headers[header].([]interface{})
+	if !ok {
+		return
+	}
+
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		pairs := strings.Split(s, ";")
+		for j, pair := range pairs {
+			name, value, ok := strings.Cut(pair, "=")
+			if ok && r.isCookie(strings.TrimSpace(name)) {
+				pairs[j] = name + "=" + r.value(value)
+			}
+
+			// only the first pair of a Set-Cookie is the cookie itself
+			if header == "Set-Cookie" {
+				break
+			}
+		}
+		values[i] = strings.Join(pairs, ";")
+	}
+}
+
+func (r *Redact) isCookie(name string) bool {
+	for _, cookie := range r.Cookies {
+		if cookie == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redact) redactURI(uri string) string {
+	path, rawQuery, ok := strings.Cut(uri, "?")
+	if !ok {
+		return uri
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return uri
+	}
+
+	changed := false
+	for _, param := range r.Query {
+		values, ok := query[param]
+		if !ok {
+			continue
+		}
+
+		for i, v := range values {
+			values[i] = r.value(v)
+		}
+		changed = true
+	}
+
+	if !changed {
+		return uri
+	}
+	return path + "?" + query.Encode()
+}
@@ -0,0 +1,115 @@
+package mongo_log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	const entry = `{
+		"request": {
+			"uri": "/login?token=secret&page=2",
+			"headers": {
+				"Authorization": ["Bearer secret"],
+				"Cookie": ["theme=dark; session=secret"],
+				"Accept": ["*/*"]
+			}
+		},
+		"resp_headers": {
+			"Set-Cookie": ["session=secret; Path=/; HttpOnly"],
+			"X-Api-Key": ["secret"]
+		}
+	}`
+
+	hashed := func(v string) string {
+		sum := sha256.Sum256([]byte("pepper" + v))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	tests := []struct {
+		name     string
+		redact   Redact
+		wantURI  string
+		wantReq  map[string]interface{}
+		wantResp map[string]interface{}
+	}{
+		{
+			name:    "replace",
+			redact:  Redact{Headers: []string{"authorization", "X-API-Key"}, Cookies: []string{"session"}, Query: []string{"token"}},
+			wantURI: "/login?page=2&token=%5BREDACTED%5D",
+			wantReq: map[string]interface{}{
+				"Authorization": []interface{}{redacted},
+				"Cookie":        []interface{}{"theme=dark; session=" + redacted},
+				"Accept":        []interface{}{"*/*"},
+			},
+			wantResp: map[string]interface{}{
+				"Set-Cookie": []interface{}{"session=" + redacted + "; Path=/; HttpOnly"},
+				"X-Api-Key":  []interface{}{redacted},
+			},
+		},
+		{
+			name:    "hash",
+			redact:  Redact{Headers: []string{"Authorization"}, Cookies: []string{"session"}, Mode: redactHash, Salt: "pepper"},
+			wantURI: "/login?token=secret&page=2",
+			wantReq: map[string]interface{}{
+				"Authorization": []interface{}{hashed("Bearer secret")},
+				"Cookie":        []interface{}{"theme=dark; session=" + hashed("secret")},
+				"Accept":        []interface{}{"*/*"},
+			},
+			wantResp: map[string]interface{}{
+				"Set-Cookie": []interface{}{"session=" + hashed("secret") + "; Path=/; HttpOnly"},
+				"X-Api-Key":  []interface{}{"secret"},
+			},
+		},
+		{
+			name:    "only the cookie itself in Set-Cookie",
+			redact:  Redact{Cookies: []string{"Path"}},
+			wantURI: "/login?token=secret&page=2",
+			wantReq: map[string]interface{}{
+				"Authorization": []interface{}{"Bearer secret"},
+				"Cookie":        []interface{}{"theme=dark; session=secret"},
+				"Accept":        []interface{}{"*/*"},
+			},
+			wantResp: map[string]interface{}{
+				"Set-Cookie": []interface{}{"session=secret; Path=/; HttpOnly"},
+				"X-Api-Key":  []interface{}{"secret"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(entry), &f); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.redact.apply(f)
+
+			if uri, _ := lookup(f, "request", "uri"); uri != tt.wantURI {
+				t.Errorf("uri = %v, want %v", uri, tt.wantURI)
+			}
+			if headers, _ := lookup(f, "request", "headers"); !reflect.DeepEqual(headers, tt.wantReq) {
+				t.Errorf("request headers = %v, want %v", headers, tt.wantReq)
+			}
+			if headers := f["resp_headers"]; !reflect.DeepEqual(headers, tt.wantResp) {
+				t.Errorf("response headers = %v, want %v", headers, tt.wantResp)
+			}
+		})
+	}
+}
+
+func TestRedactNoRequest(t *testing.T) {
+	r := &Redact{Headers: []string{"Authorization"}, Query: []string{"token"}}
+
+	// entries from other loggers carry no request
+	f := map[string]interface{}{"msg": "started"}
+	r.apply(f)
+
+	if !reflect.DeepEqual(f, map[string]interface{}{"msg": "started"}) {
+		t.Errorf("apply changed an entry without a request: %v", f)
+	}
+}
@@ -0,0 +1,75 @@
+package mongo_log
+
+import (
+	"sort"
+	"sync"
+)
+
+// writers tracks the open writers by WriterKey so the admin API and
+// handlers can reach them.
+var writers = struct {
+	sync.RWMutex
+	m map[string]*mongoWriter
+}{
+	m: map[string]*mongoWriter{},
+}
+
+func registerWriter(w *mongoWriter) {
+	writers.Lock()
+	writers.m[w.config.WriterKey()] = w
+	writers.Unlock()
+}
+
+// unregisterWriter removes w unless a writer opened by a newer config has
+// already taken its place.
+func unregisterWriter(w *mongoWriter) {
+	key := w.config.WriterKey()
+
+	writers.Lock()
+	if writers.m[key] == w {
+		delete(writers.m, key)
+	}
+	writers.Unlock()
+}
+
+// lookupWriter returns the writer named by its metrics label, or by
+// database.collection if only one writer writes there. An empty name
+// matches the only open writer.
+func lookupWriter(name string) (*mongoWriter, bool) {
+	writers.RLock()
+	defer writers.RUnlock()
+
+	if name == "" && len(writers.m) == 1 {
+		for _, w := range writers.m {
+			return w, true
+		}
+	}
+
+	var match *mongoWriter
+	matches := 0
+	for _, w := range writers.m {
+		if w.metricsLabel == name {
+			return w, true
+		}
+		if w.config.Database+"."+w.config.Collection == name {
+			match = w
+			matches++
+		}
+	}
+
+	return match, matches == 1
+}
+
+// writerNames returns the metrics labels of the open writers.
+func writerNames() []string {
+	writers.RLock()
+	defer writers.RUnlock()
+
+	names := make([]string, 0, len(writers.m))
+	for _, w := range writers.m {
+		names = append(names, w.metricsLabel)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,192 @@
+package mongo_log
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRollupCollection = "rollup_hourly"
+	defaultRollupInterval   = 5 * time.Minute
+	defaultRollupLookback   = 2 * time.Hour
+	rollupTimeout           = 5 * time.Minute
+)
+
+// Rollup maintains per hour, host and status request counts, bytes and
+// latencies in a separate collection through a $merge pipeline, so
+// dashboards need not scan raw documents. Every Interval the hours within
+// Lookback are recomputed. Needs MongoDB 7.0 for $percentile.
+type Rollup struct {
+	Collection string         `json:"collection,omitempty"`
+	Interval   caddy.Duration `json:"interval,omitempty"`
+	Lookback   caddy.Duration `json:"lookback,omitempty"`
+
+	// Document paths grouped and measured, defaulting to the access log
+	// fields in metadata.
+	HostField     string `json:"host_field,omitempty"`
+	StatusField   string `json:"status_field,omitempty"`
+	DurationField string `json:"duration_field,omitempty"`
+	SizeField     string `json:"size_field,omitempty"`
+}
+
+func parseRollup(d *caddyfile.Dispenser) (*Rollup, error) {
+	r := &Rollup{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		option := d.Val()
+
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+
+		switch option {
+		case "collection":
+			r.Collection = d.Val()
+
+		case "interval", "lookback":
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid rollup %s %q: %v", option, d.Val(), err)
+			}
+			if option == "interval" {
+				r.Interval = caddy.Duration(dur)
+			} else {
+				r.Lookback = caddy.Duration(dur)
+			}
+
+		case "host_field":
+			r.HostField = d.Val()
+
+		case "status_field":
+			r.StatusField = d.Val()
+
+		case "duration_field":
+			r.DurationField = d.Val()
+
+		case "size_field":
+			r.SizeField = d.Val()
+
+		default:
+			return nil, d.Errf("unrecognized rollup option %q", option)
+		}
+	}
+
+	return r, nil
+}
+
+// provision fills in defaults, with the fields found through metadataField.
+func (r *Rollup) provision(metadataField func(string) string) {
+	if r.Collection == "" {
+		r.Collection = defaultRollupCollection
+	}
+	if r.Interval <= 0 {
+		r.Interval = caddy.Duration(defaultRollupInterval)
+	}
+	if r.Lookback <= 0 {
+		r.Lookback = caddy.Duration(defaultRollupLookback)
+	}
+	if r.HostField == "" {
+		r.HostField = metadataField("request.host")
+	}
+	if r.StatusField == "" {
+		r.StatusField = metadataField("status")
+	}
+	if r.DurationField == "" {
+		r.DurationField = metadataField("duration")
+	}
+	if r.SizeField == "" {
+		r.SizeField = metadataField("size")
+	}
+}
+
+// pipeline aggregates the whole hours since start of one source collection
+// into the rollup collection, replacing buckets computed earlier.
+func (r *Rollup) pipeline(t target, dateField string, start time.Time) bson.A {
+	return bson.A{
+		bson.M{"$match": bson.M{dateField: bson.M{"$gte": start}}},
+		bson.M{"$group": bson.M{
+			"_id": bson.M{
+				"collection": t.collection,
+				"hour":       bson.M{"$dateTrunc": bson.M{"date": "$" + dateField, "unit": "hour"}},
+				"host":       "$" + r.HostField,
+				"status":     "$" + r.StatusField,
+			},
+			"count":        bson.M{"$sum": 1},
+			"bytes":        bson.M{"$sum": "$" + r.SizeField},
+			"avg_duration": bson.M{"$avg": "$" + r.DurationField},
+			"max_duration": bson.M{"$max": "$" + r.DurationField},
+			"p95_duration": bson.M{"$percentile": bson.M{
+				"input":  "$" + r.DurationField,
+				"p":      bson.A{0.95},
+				"method": "approximate",
+			}},
+		}},
+		bson.M{"$set": bson.M{
+			"collection":   "$_id.collection",
+			"hour":         "$_id.hour",
+			"host":         "$_id.host",
+			"status":       "$_id.status",
+			"p95_duration": bson.M{"$arrayElemAt": bson.A{"$p95_duration", 0}},
+		}},
+		bson.M{"$merge": bson.M{
+			"into":           bson.M{"db": t.database, "coll": r.Collection},
+			"on":             "_id",
+			"whenMatched":    "replace",
+			"whenNotMatched": "insert",
+		}},
+	}
+}
+
+// rollupLoop refreshes the rollup every interval until done is closed.
+func (mWrite *mongoWriter) rollupLoop() {
+	defer mWrite.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(mWrite.config.Rollup.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			mWrite.rollup()
+		case <-mWrite.done:
+			return
+		}
+	}
+}
+
+func (mWrite *mongoWriter) rollup() {
+	r := mWrite.config.Rollup
+	start := time.Now().Add(-time.Duration(r.Lookback)).Truncate(time.Hour)
+
+	for _, t := range mWrite.knownTargets() {
+		if t.collection == r.Collection {
+			continue
+		}
+
+		if err := mWrite.rollupCollection(t, start); err != nil {
+			mWrite.logger.Error("Could not update log rollup", zap.String("collection", t.collection), zap.Error(err))
+		}
+	}
+}
+
+func (mWrite *mongoWriter) rollupCollection(t target, start time.Time) error {
+	collection, err := mWrite.collectionFor(t)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rollupTimeout)
+	defer cancel()
+
+	cursor, err := collection.Aggregate(ctx, mWrite.config.Rollup.pipeline(t, mWrite.config.dateField(), start))
+	if err != nil {
+		return fmt.Errorf("aggregating: %v", err)
+	}
+	return cursor.Close(ctx)
+}
@@ -0,0 +1,56 @@
+package mongo_log
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expandCollectionName formats every {layout} segment of name that contains
+// a digit as a Go time layout, in UTC, so "access_{2006-01-02}" rotates
+// daily. "WW" inside a layout is the ISO week number. Other segments, such
+// as placeholders, are left alone.
+func expandCollectionName(name string, t time.Time) string {
+	if !strings.Contains(name, "{") {
+		return name
+	}
+
+	t = t.UTC()
+
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(name, '{')
+		if start < 0 {
+			break
+		}
+
+		end := strings.IndexByte(name[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+
+		layout := name[start+1 : end]
+		b.WriteString(name[:start])
+
+		if strings.ContainsAny(layout, "0123456789") {
+			_, week := t.ISOWeek()
+			formatted := t.Format(layout)
+			b.WriteString(strings.ReplaceAll(formatted, "WW", leftPad(strconv.Itoa(week), 2)))
+		} else {
+			b.WriteString(name[start : end+1])
+		}
+
+		name = name[end+1:]
+	}
+
+	b.WriteString(name)
+	return b.String()
+}
+
+func leftPad(s string, n int) string {
+	for len(s) < n {
+		s = "0" + s
+	}
+	return s
+}
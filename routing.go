@@ -0,0 +1,85 @@
+package mongo_log
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// target returns where a log entry recorded at t belongs.
+func (l *MongoLog) target(entry map[string]interface{}, t time.Time) target {
+	database, collection := l.Database, l.Collection
+
+	if routed, ok := l.LevelRoutes[strings.ToLower(lookupString(entry, "level"))]; ok {
+		collection = routed
+	} else if route, ok := l.routeLogger(lookupString(entry, "logger")); ok {
+		if route.Database != "" {
+			database = route.Database
+		}
+		collection = route.Collection
+	} else if routed, ok := l.routeHost(lookupString(entry, "request", "host")); ok {
+		collection = routed
+	}
+
+	if l.Tenant != nil {
+		database, collection = l.Tenant.route(entry, database, collection)
+	}
+
+	return target{
+		database:   database,
+		collection: expandCollectionName(collection, t),
+	}
+}
+
+// routeHost maps a request host to its routed collection. Routes are exact
+// host names or "*.example.com" wildcards; exact matches win.
+func (l *MongoLog) routeHost(host string) (string, bool) {
+	if len(l.Routes) == 0 || host == "" {
+		return "", false
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	if collection, ok := l.Routes[host]; ok {
+		return collection, true
+	}
+
+	for i := strings.IndexByte(host, '.'); i >= 0; i = strings.IndexByte(host, '.') {
+		host = host[i+1:]
+		if collection, ok := l.Routes["*."+host]; ok {
+			return collection, true
+		}
+	}
+
+	return "", false
+}
+
+// LoggerRoute is where a logger's entries are written. An empty Database
+// keeps the writer's database.
+type LoggerRoute struct {
+	Database   string `json:"database,omitempty"`
+	Collection string `json:"collection,omitempty"`
+}
+
+// routeLogger maps a logger name to its route, matching the longest
+// configured name that is the logger itself or one of its parents.
+func (l *MongoLog) routeLogger(name string) (LoggerRoute, bool) {
+	if len(l.LoggerRoutes) == 0 || name == "" {
+		return LoggerRoute{}, false
+	}
+
+	for {
+		if route, ok := l.LoggerRoutes[name]; ok {
+			return route, true
+		}
+
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			return LoggerRoute{}, false
+		}
+		name = name[:i]
+	}
+}
@@ -0,0 +1,111 @@
+package mongo_log
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func validateSampleRate(name string, rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("%s must be between 0 and 1", name)
+	}
+	return nil
+}
+
+// sampled reports whether an entry is kept under the configured sampling.
+func (l *MongoLog) sampled(entry map[string]interface{}) bool {
+	if rate, ok := l.statusSampleRate(entry); ok {
+		return rand.Float64() < rate
+	}
+
+	rate := l.SampleRate
+
+	if l.SuccessSampleRate != nil {
+		if isErrorEntry(entry) {
+			return true
+		}
+		rate = l.SuccessSampleRate
+	}
+
+	if rate == nil {
+		return true
+	}
+
+	return rand.Float64() < *rate
+}
+
+// statusSampleRate looks up the entry status in the sample map, preferring
+// the exact code over its class.
+func (l *MongoLog) statusSampleRate(entry map[string]interface{}) (float64, bool) {
+	if len(l.Sample) == 0 {
+		return 0, false
+	}
+
+	status, ok := toFloat(entry["status"])
+	if !ok {
+		return 0, false
+	}
+
+	code := strconv.Itoa(int(status))
+	if rate, ok := l.Sample[code]; ok {
+		return rate, true
+	}
+
+	rate, ok := l.Sample[code[:1]+"xx"]
+	return rate, ok
+}
+
+func validateSampleMap(sample map[string]float64) error {
+	for key, rate := range sample {
+		valid := len(key) == 3 && key[0] >= '1' && key[0] <= '5' &&
+			(key[1:] == "xx" || (isDigit(key[1]) && isDigit(key[2])))
+		if !valid {
+			return fmt.Errorf("invalid sample status %q, want a code like 404 or a class like 5xx", key)
+		}
+
+		if err := validateSampleRate("sample "+key, rate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isErrorEntry reports whether an entry has a 4xx/5xx status or was logged
+// at warn level or above.
+func isErrorEntry(entry map[string]interface{}) bool {
+	if status, ok := toFloat(entry["status"]); ok && status >= 400 {
+		return true
+	}
+
+	if s, ok := entry["level"].(string); ok {
+		if level, err := zapcore.ParseLevel(s); err == nil && level >= zapcore.WarnLevel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// belowMinLevel reports whether an entry is less severe than MinLevel.
+// Entries without a level are kept.
+func (l *MongoLog) belowMinLevel(entry map[string]interface{}) bool {
+	if l.MinLevel == "" {
+		return false
+	}
+
+	s, ok := entry["level"].(string)
+	if !ok {
+		return false
+	}
+
+	level, err := zapcore.ParseLevel(s)
+	return err == nil && level < l.minLevel
+}
@@ -0,0 +1,145 @@
+package mongo_log
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	schemaActionError = "error"
+	schemaActionWarn  = "warn"
+)
+
+// coerceBSONTypes maps coerce types to the $jsonSchema bsonType they store.
+var coerceBSONTypes = map[string]string{
+	coerceInt:          "long",
+	coerceDouble:       "double",
+	coerceBool:         "bool",
+	coerceString:       "string",
+	coerceMilliseconds: "double",
+}
+
+func parseSchemaValidation(d *caddyfile.Dispenser) (string, error) {
+	if !d.NextArg() {
+		return schemaActionError, nil
+	}
+
+	switch d.Val() {
+	case schemaActionError, schemaActionWarn:
+		return d.Val(), nil
+	default:
+		return "", d.Errf("invalid schema_validation %q, want error or warn", d.Val())
+	}
+}
+
+// jsonSchema describes the documents this config writes: the envelope or
+// template fields, plus the types fixed by coerce and date_fields.
+func (l *MongoLog) jsonSchema() bson.M {
+	schema := bson.M{"bsonType": "object"}
+
+	if len(l.Template) > 0 {
+		for field, value := range l.Template {
+			switch value {
+			case templateDate:
+				addSchemaField(schema, splitPath(field), "date", true)
+			case templateTags, templateMetadata:
+				addSchemaField(schema, splitPath(field), "object", true)
+			}
+		}
+		addSchemaField(schema, splitPath(l.dateField()), "date", true)
+		return schema
+	}
+
+	addSchemaField(schema, []string{"tags"}, "object", true)
+	addSchemaField(schema, []string{l.dateField()}, "date", true)
+
+	// flattened metadata has no nested paths to describe
+	if l.Flatten {
+		if !l.TopLevel {
+			addSchemaField(schema, []string{"metadata"}, "object", true)
+		}
+		return schema
+	}
+
+	for field, kind := range l.Coerce {
+		// encrypted fields are stored as binary whatever their type
+		if l.Encryption != nil && slices.Contains(l.Encryption.Fields, field) {
+			continue
+		}
+		addSchemaField(schema, splitPath(l.metadataField(field)), coerceBSONTypes[kind], false)
+	}
+
+	for _, field := range l.DateFields {
+		if l.Encryption != nil && slices.Contains(l.Encryption.Fields, field) {
+			continue
+		}
+		addSchemaField(schema, splitPath(l.metadataField(field)), "date", false)
+	}
+
+	if !l.TopLevel {
+		addSchemaField(schema, []string{"metadata"}, "object", true)
+	}
+	return schema
+}
+
+// addSchemaField declares the type of path in an object schema, creating
+// the parent objects along the way.
+func addSchemaField(schema bson.M, path []string, bsonType string, required bool) {
+	for i, key := range path {
+		properties, _ := schema["properties"].(bson.M)
+		if properties == nil {
+			properties = bson.M{}
+			schema["properties"] = properties
+		}
+
+		if required {
+			req, _ := schema["required"].(bson.A)
+			if !containsValue(req, key) {
+				schema["required"] = append(req, key)
+			}
+		}
+
+		child, _ := properties[key].(bson.M)
+		if child == nil {
+			child = bson.M{}
+			properties[key] = child
+		}
+
+		if i == len(path)-1 {
+			child["bsonType"] = bsonType
+			return
+		}
+
+		child["bsonType"] = "object"
+		schema = child
+	}
+}
+
+func containsValue(a bson.A, v interface{}) bool {
+	for _, x := range a {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// updateValidator replaces the validator of an existing collection, so
+// config changes reach collections created by an earlier run.
+func (l *MongoLog) updateValidator(ctx context.Context, db *mongo.Database, name string) error {
+	err := db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: name},
+		{Key: "validator", Value: bson.M{"$jsonSchema": l.jsonSchema()}},
+		{Key: "validationLevel", Value: "moderate"},
+		{Key: "validationAction", Value: l.SchemaValidation},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("updating validator of %s.%s: %v", db.Name(), name, err)
+	}
+	return nil
+}
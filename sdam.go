@@ -0,0 +1,58 @@
+package mongo_log
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.uber.org/zap"
+)
+
+// serverMonitor reports the driver's view of the deployment: servers
+// joining, leaving or changing kind, and failed heartbeats.
+func (l *MongoLog) serverMonitor() *event.ServerMonitor {
+	logger := l.logger.Named("sdam")
+
+	return &event.ServerMonitor{
+		ServerOpening: func(e *event.ServerOpeningEvent) {
+			logger.Info("Mongo server added", zap.String("address", e.Address.String()))
+		},
+		ServerClosed: func(e *event.ServerClosedEvent) {
+			logger.Info("Mongo server removed", zap.String("address", e.Address.String()))
+		},
+		ServerDescriptionChanged: func(e *event.ServerDescriptionChangedEvent) {
+			prev, next := e.PreviousDescription.Kind, e.NewDescription.Kind
+			if prev == next {
+				return
+			}
+
+			mongoMetrics.serverChanges.WithLabelValues(e.Address.String(), next.String()).Inc()
+
+			log := logger.Info
+			if next == description.Unknown {
+				log = logger.Warn
+			}
+			log("Mongo server changed",
+				zap.String("address", e.Address.String()),
+				zap.String("previous", prev.String()),
+				zap.String("kind", next.String()),
+				zap.NamedError("last_error", e.NewDescription.LastError))
+		},
+		TopologyDescriptionChanged: func(e *event.TopologyDescriptionChangedEvent) {
+			if e.PreviousDescription.Kind != e.NewDescription.Kind {
+				logger.Info("Mongo topology changed",
+					zap.String("previous", e.PreviousDescription.Kind.String()),
+					zap.String("kind", e.NewDescription.Kind.String()))
+			}
+		},
+		ServerHeartbeatFailed: func(e *event.ServerHeartbeatFailedEvent) {
+			// connection IDs look like "host:27017[-3]"
+			address, _, _ := strings.Cut(e.ConnectionID, "[")
+			mongoMetrics.heartbeatFailures.WithLabelValues(address).Inc()
+			logger.Warn("Mongo server heartbeat failed",
+				zap.String("connection", e.ConnectionID),
+				zap.Duration("duration", e.Duration),
+				zap.Error(e.Failure))
+		},
+	}
+}
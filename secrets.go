@@ -0,0 +1,55 @@
+package mongo_log
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// readSecret reads a secret from disk, ignoring a trailing newline.
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolveCredentials fills the connection string and credentials from
+// their *_file options and expands placeholders such as {env.MONGO_URI}.
+func (l *MongoLog) resolveCredentials() error {
+	secrets := []struct {
+		name  string
+		file  string
+		value *string
+	}{
+		{"mongo_uri_file", l.MongoUriFile, &l.MongoUri},
+		{"username_file", l.UsernameFile, &l.Username},
+		{"password_file", l.PasswordFile, &l.Password},
+	}
+
+	repl := caddy.NewReplacer()
+
+	for _, secret := range secrets {
+		if secret.file != "" {
+			value, err := readSecret(repl.ReplaceKnown(secret.file, ""))
+			if err != nil {
+				return fmt.Errorf("reading %s: %v", secret.name, err)
+			}
+			*secret.value = value
+		}
+
+		*secret.value = repl.ReplaceKnown(*secret.value, "")
+	}
+
+	l.AuthSource = repl.ReplaceKnown(l.AuthSource, "")
+
+	for i, uri := range l.FallbackUris {
+		l.FallbackUris[i] = repl.ReplaceKnown(uri, "")
+	}
+
+	return nil
+}
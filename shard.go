@@ -0,0 +1,81 @@
+package mongo_log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const shardHashed = ":hashed"
+
+// codeAlreadyInitialized is returned by enableSharding before 4.2 when the
+// database already has sharding enabled.
+const codeAlreadyInitialized = 23
+
+// shardKey turns ["metadata.request.host:hashed", "date"] into a shard key
+// document.
+func shardKey(fields []string) bson.D {
+	keys := make(bson.D, 0, len(fields))
+	for _, field := range fields {
+		if name, ok := strings.CutSuffix(field, shardHashed); ok {
+			keys = append(keys, bson.E{Key: name, Value: "hashed"})
+		} else {
+			keys = append(keys, bson.E{Key: field, Value: 1})
+		}
+	}
+
+	return keys
+}
+
+func (l *MongoLog) validateShardKey() error {
+	hashed := 0
+	for _, field := range l.ShardKey {
+		if strings.HasSuffix(field, shardHashed) {
+			hashed++
+		}
+	}
+
+	switch {
+	case hashed > 1:
+		return fmt.Errorf("shard_key can hash at most one field")
+	case l.Capped:
+		return fmt.Errorf("capped collections cannot be sharded")
+	case l.Compat != "":
+		return fmt.Errorf("shard_key is not supported with compat %s", l.Compat)
+	}
+
+	return nil
+}
+
+// ensureSharded shards collection on ShardKey. It is safe to call on every
+// connect: sharding an already sharded collection with the same key is a
+// no-op.
+func (l *MongoLog) ensureSharded(ctx context.Context, collection *mongo.Collection) error {
+	admin := collection.Database().Client().Database("admin")
+
+	// required before 6.0, where it is implied by shardCollection
+	err := admin.RunCommand(ctx, bson.D{{Key: "enableSharding", Value: collection.Database().Name()}}).Err()
+	if err != nil && !isAlreadyInitialized(err) {
+		return err
+	}
+
+	// shardCollection only creates the supporting index on empty collections
+	key := shardKey(l.ShardKey)
+	if _, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: key}); err != nil && !isIndexConflict(err) {
+		return err
+	}
+
+	return admin.RunCommand(ctx, bson.D{
+		{Key: "shardCollection", Value: collection.Database().Name() + "." + collection.Name()},
+		{Key: "key", Value: key},
+	}).Err()
+}
+
+func isAlreadyInitialized(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == codeAlreadyInitialized
+}
@@ -0,0 +1,259 @@
+package mongo_log
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSpoolMaxSize = 100 << 20
+	spoolReplayInterval = 30 * time.Second
+	spoolExt            = ".bson"
+)
+
+// openSpool creates the spool directory and accounts for any batches left
+// over from a previous run.
+func (mWrite *mongoWriter) openSpool() error {
+	if err := os.MkdirAll(mWrite.spoolDir, 0o700); err != nil {
+		return fmt.Errorf("creating spool dir: %v", err)
+	}
+
+	files, err := mWrite.spoolFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			mWrite.spoolSize += info.Size()
+		}
+	}
+
+	return nil
+}
+
+// spoolFiles lists the spooled batches, oldest first.
+func (mWrite *mongoWriter) spoolFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(mWrite.spoolDir, "*"+spoolExt))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// spool writes a failed batch to disk as concatenated BSON documents,
+// preceded by a header naming its target.
+func (mWrite *mongoWriter) spool(t target, docs []interface{}) error {
+	data, err := encodeSpool(t, docs)
+	if err != nil {
+		return err
+	}
+
+	mWrite.spoolMu.Lock()
+	defer mWrite.spoolMu.Unlock()
+
+	if mWrite.spoolSize+int64(len(data)) > mWrite.spoolMaxSize {
+		return fmt.Errorf("spool is full (%d bytes)", mWrite.spoolSize)
+	}
+
+	name := fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), mWrite.spoolSeq.Add(1))
+	tmp := filepath.Join(mWrite.spoolDir, name+".tmp")
+
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// rename so the replay loop never reads a partially written batch
+	if err := os.Rename(tmp, filepath.Join(mWrite.spoolDir, name+spoolExt)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	mWrite.spoolSize += int64(len(data))
+	return nil
+}
+
+func encodeSpool(t target, docs []interface{}) ([]byte, error) {
+	data, err := bson.Marshal(spoolHeader{Database: t.database, Collection: t.collection, Auxiliary: t.auxiliary})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, raw...)
+	}
+
+	return data, nil
+}
+
+// rewriteSpool replaces a spooled batch with the documents of it that are
+// left to insert.
+func (mWrite *mongoWriter) rewriteSpool(file string, size int64, t target, docs []interface{}) error {
+	data, err := encodeSpool(t, docs)
+	if err != nil {
+		return err
+	}
+
+	tmp := strings.TrimSuffix(file, spoolExt) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	mWrite.releaseSpool(size - int64(len(data)))
+	return nil
+}
+
+type spoolHeader struct {
+	Database   string `bson:"database"`
+	Collection string `bson:"collection"`
+	Auxiliary  bool   `bson:"auxiliary,omitempty"`
+}
+
+// readSpoolFile splits a spooled batch back into its target and documents.
+func readSpoolFile(path string) (target, []interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return target{}, nil, err
+	}
+
+	var docs []bson.Raw
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return target{}, nil, fmt.Errorf("truncated document in %s", path)
+		}
+
+		size := int(binary.LittleEndian.Uint32(data))
+		if size < 5 || size > len(data) {
+			return target{}, nil, fmt.Errorf("truncated document in %s", path)
+		}
+
+		doc := bson.Raw(data[:size])
+		if err := doc.Validate(); err != nil {
+			return target{}, nil, fmt.Errorf("corrupt document in %s: %v", path, err)
+		}
+
+		docs = append(docs, doc)
+		data = data[size:]
+	}
+
+	if len(docs) == 0 {
+		return target{}, nil, fmt.Errorf("missing header in %s", path)
+	}
+
+	var header spoolHeader
+	if err := bson.Unmarshal(docs[0], &header); err != nil || header.Collection == "" {
+		return target{}, nil, fmt.Errorf("invalid header in %s", path)
+	}
+
+	batch := make([]interface{}, 0, len(docs)-1)
+	for _, doc := range docs[1:] {
+		batch = append(batch, doc)
+	}
+
+	return target{database: header.Database, collection: header.Collection, auxiliary: header.Auxiliary}, batch, nil
+}
+
+// replaySpool drains spooled batches into the collection, stopping at the
+// first failed insert. Documents the server rejects are dead-lettered
+// like in insert, and a partly inserted batch is cut down to the rest.
+func (mWrite *mongoWriter) replaySpool() {
+	if mWrite.breakerOpen() {
+		return
+	}
+
+	files, err := mWrite.spoolFiles()
+	if err != nil {
+		mWrite.logger.Error("Could not list spool dir", zap.Error(err))
+		return
+	}
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+
+		t, docs, err := readSpoolFile(file)
+		if err != nil {
+			mWrite.logger.Error("Discarding unreadable spool file", zap.String("file", file), zap.Error(err))
+			os.Rename(file, strings.TrimSuffix(file, spoolExt)+".corrupt")
+			mWrite.releaseSpool(info.Size())
+			continue
+		}
+
+		if len(docs) > 0 {
+			collection, err := mWrite.collectionFor(t)
+			if err != nil {
+				return
+			}
+
+			if err := mWrite.writeDocs(context.Background(), collection, docs); err != nil {
+				var bwe mongo.BulkWriteException
+				if !errors.As(err, &bwe) || bwe.WriteConcernError != nil || len(bwe.WriteErrors) == 0 {
+					return
+				}
+
+				if retry := mWrite.splitRejected(t, docs, bwe.WriteErrors); len(retry) > 0 {
+					if err := mWrite.rewriteSpool(file, info.Size(), t, retry); err != nil {
+						mWrite.logger.Error("Could not rewrite partly replayed spool file", zap.String("file", file), zap.Error(err))
+					}
+					return
+				}
+			}
+		}
+
+		if err := os.Remove(file); err != nil {
+			mWrite.logger.Error("Could not remove replayed spool file", zap.String("file", file), zap.Error(err))
+			return
+		}
+		mWrite.releaseSpool(info.Size())
+
+		mWrite.logger.Info("Replayed spooled log batch", zap.String("file", file), zap.Int("count", len(docs)))
+	}
+}
+
+func (mWrite *mongoWriter) releaseSpool(size int64) {
+	mWrite.spoolMu.Lock()
+	mWrite.spoolSize -= size
+	mWrite.spoolMu.Unlock()
+}
+
+func (mWrite *mongoWriter) spoolLoop() {
+	defer mWrite.wg.Done()
+
+	ticker := time.NewTicker(spoolReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			mWrite.replaySpool()
+		case <-mWrite.done:
+			return
+		}
+	}
+}
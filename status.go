@@ -0,0 +1,65 @@
+package mongo_log
+
+import (
+	"time"
+)
+
+// writerStatus is a snapshot of a writer's delivery state.
+type writerStatus struct {
+	Writer       string     `json:"writer"`
+	Connected    bool       `json:"connected"`
+	Database     string     `json:"database"`
+	Collection   string     `json:"collection"`
+	LastInsert   *time.Time `json:"last_insert,omitempty"`
+	QueueDepth   int        `json:"queue_depth"`
+	Pending      int        `json:"pending"`
+	Inserted     uint64     `json:"inserted"`
+	InsertErrors uint64     `json:"insert_errors"`
+	Dropped      uint64     `json:"dropped"`
+	SpoolBytes   int64      `json:"spool_bytes,omitempty"`
+	BreakerOpen  bool       `json:"breaker_open,omitempty"`
+}
+
+func (mWrite *mongoWriter) status() writerStatus {
+	t := mWrite.config.target(nil, time.Now())
+
+	mWrite.mu.Lock()
+	pending := len(mWrite.batch)
+	mWrite.mu.Unlock()
+
+	mWrite.spoolMu.Lock()
+	spoolBytes := mWrite.spoolSize
+	mWrite.spoolMu.Unlock()
+
+	s := writerStatus{
+		Writer:       mWrite.metricsLabel,
+		Connected:    mWrite.connected.Load(),
+		Database:     t.database,
+		Collection:   t.collection,
+		QueueDepth:   len(mWrite.queue),
+		Pending:      pending,
+		Inserted:     mWrite.inserted.Load(),
+		InsertErrors: mWrite.insertErrors.Load(),
+		Dropped:      mWrite.dropped.Load(),
+		SpoolBytes:   spoolBytes,
+		BreakerOpen:  mWrite.breakerOpen(),
+	}
+
+	if last := mWrite.lastInsert.Load(); last > 0 {
+		t := time.Unix(0, last)
+		s.LastInsert = &t
+	}
+
+	return s
+}
+
+func writerStatuses() []writerStatus {
+	var statuses []writerStatus
+	for _, name := range writerNames() {
+		if w, ok := lookupWriter(name); ok {
+			statuses = append(statuses, w.status())
+		}
+	}
+
+	return statuses
+}
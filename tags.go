@@ -0,0 +1,46 @@
+package mongo_log
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// resolveTags builds the tags sub-document for an entry. Tag values may use
+// global placeholders like {system.hostname} or {env.REGION}, and
+// {entry.<path>} to copy a field of the entry, e.g. {entry.request.host}.
+func (mWrite *mongoWriter) resolveTags(entry map[string]interface{}) bson.M {
+	tags := bson.M{}
+	if len(mWrite.tags) == 0 {
+		return tags
+	}
+
+	repl := entryReplacer(entry)
+	for key, value := range mWrite.tags {
+		tags[key] = repl.ReplaceAll(value, "")
+	}
+
+	return tags
+}
+
+// entryReplacer returns a replacer that also resolves {entry.<path>}
+// placeholders against entry.
+func entryReplacer(entry map[string]interface{}) *caddy.Replacer {
+	repl := caddy.NewReplacer()
+	repl.Map(func(key string) (any, bool) {
+		path, ok := strings.CutPrefix(key, "entry.")
+		if !ok {
+			return nil, false
+		}
+
+		v, ok := lookup(entry, strings.Split(path, ".")...)
+		if !ok {
+			return "", true
+		}
+		return fmt.Sprint(v), true
+	})
+
+	return repl
+}
@@ -0,0 +1,157 @@
+package mongo_log
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const tailKeepalive = 15 * time.Second
+
+func init() {
+	caddy.RegisterModule(MongoLogTail{})
+	httpcaddyfile.RegisterHandlerDirective("mongo_log_tail", parseTailCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("mongo_log_tail", httpcaddyfile.Before, "abort")
+}
+
+// MongoLogTail streams documents inserted into a writer's collection as
+// server-sent events, using a change stream. The host and status query
+// parameters filter the stream, and clients resume from Last-Event-ID
+// after reconnecting. Change streams need a replica set or sharded
+// cluster.
+type MongoLogTail struct {
+	// The writer to tail, named by database.collection. May be empty when
+	// only one writer is open.
+	Writer string `json:"writer,omitempty"`
+}
+
+// CaddyModule implements caddy.Module.
+func (MongoLogTail) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.mongo_log_tail",
+		New: func() caddy.Module { return new(MongoLogTail) },
+	}
+}
+
+func (m MongoLogTail) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	writer, ok := lookupWriter(m.Writer)
+	if !ok {
+		return caddyhttp.Error(http.StatusServiceUnavailable, fmt.Errorf("no mongo_log writer %q", m.Writer))
+	}
+
+	query := r.URL.Query()
+	filter, err := writer.config.queryFilter("", "", query.Get("status"), query.Get("host"), "", "")
+	if err != nil {
+		return caddyhttp.Error(http.StatusBadRequest, err)
+	}
+
+	match := bson.M{"operationType": "insert"}
+	for key, value := range filter {
+		match["fullDocument."+key] = value
+	}
+
+	collection, err := writer.collectionFor(writer.config.target(nil, time.Now()))
+	if err != nil {
+		return caddyhttp.Error(http.StatusServiceUnavailable, err)
+	}
+
+	opts := options.ChangeStream().SetMaxAwaitTime(tailKeepalive)
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		opts.SetResumeAfter(bson.M{"_data": id})
+	}
+
+	stream, err := collection.Watch(r.Context(), bson.A{bson.M{"$match": match}}, opts)
+	if err != nil {
+		return caddyhttp.Error(http.StatusBadGateway, err)
+	}
+	defer stream.Close(r.Context())
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	for {
+		if !stream.TryNext(r.Context()) {
+			if stream.Err() != nil || r.Context().Err() != nil {
+				return nil
+			}
+
+			// nothing new within the await time
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			rc.Flush()
+			continue
+		}
+
+		var event struct {
+			FullDocument bson.M `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&event); err != nil {
+			continue
+		}
+
+		data, err := json.Marshal(event.FullDocument)
+		if err != nil {
+			continue
+		}
+
+		token, _ := stream.ResumeToken().Lookup("_data").StringValueOK()
+		if _, err := fmt.Fprintf(w, "id: %s\ndata: %s\n\n", token, data); err != nil {
+			return nil
+		}
+		rc.Flush()
+	}
+}
+
+func (m *MongoLogTail) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			m.Writer = d.Val()
+		}
+
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "writer":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				m.Writer = d.Val()
+
+			default:
+				return d.Errf("unrecognized mongo_log_tail option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseTailCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	m := new(MongoLogTail)
+	err := m.UnmarshalCaddyfile(h.Dispenser)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Interface guards.
+var (
+	_ caddyhttp.MiddlewareHandler = (*MongoLogTail)(nil)
+	_ caddyfile.Unmarshaler       = (*MongoLogTail)(nil)
+)
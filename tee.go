@@ -0,0 +1,30 @@
+package mongo_log
+
+import (
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// parseWriterOpener parses a log output module the way the log directive's
+// output subdirective does, with the dispenser on the module name.
+func parseWriterOpener(d *caddyfile.Dispenser) (caddy.WriterOpener, error) {
+	switch d.Val() {
+	case "stdout":
+		return caddy.StdoutWriter{}, nil
+	case "stderr":
+		return caddy.StderrWriter{}, nil
+	case "discard":
+		return caddy.DiscardWriter{}, nil
+	}
+
+	unm, err := caddyfile.UnmarshalModule(d, "caddy.logging.writers."+d.Val())
+	if err != nil {
+		return nil, err
+	}
+
+	wo, ok := unm.(caddy.WriterOpener)
+	if !ok {
+		return nil, d.Errf("module %s (%T) is not a WriterOpener", d.Val(), unm)
+	}
+	return wo, nil
+}
@@ -0,0 +1,73 @@
+package mongo_log
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Template values that are replaced by parts of the default envelope.
+const (
+	templateDate     = "{date}"
+	templateTags     = "{tags}"
+	templateMetadata = "{metadata}"
+)
+
+// renderTemplate builds a document from the Template, whose keys are
+// dot-paths in the output. A value that is a single {entry.<path>}
+// placeholder copies that field with its type; other values are strings
+// with placeholders replaced. The date field is always set so TTL indexes
+// and time series collections keep working.
+func (l *MongoLog) renderTemplate(entry map[string]interface{}, metadata interface{}, tags bson.M, now time.Time) bson.M {
+	doc := map[string]interface{}{}
+	date := primitive.NewDateTimeFromTime(now)
+	repl := entryReplacer(entry)
+
+	for field, value := range l.Template {
+		var v interface{}
+
+		switch value {
+		case templateDate:
+			v = date
+		case templateTags:
+			v = tags
+		case templateMetadata:
+			v = metadata
+		default:
+			if path, ok := singlePlaceholder(value); ok {
+				found, ok := lookup(entry, splitPath(path)...)
+				if !ok {
+					continue
+				}
+				v = found
+			} else {
+				v = repl.ReplaceAll(value, "")
+			}
+		}
+
+		setPath(doc, splitPath(field), v)
+	}
+
+	if _, ok := lookup(doc, splitPath(l.dateField())...); !ok {
+		setPath(doc, splitPath(l.dateField()), date)
+	}
+
+	return doc
+}
+
+// singlePlaceholder returns the path of a value that is exactly one
+// {entry.<path>} placeholder.
+func singlePlaceholder(value string) (string, bool) {
+	inner, ok := strings.CutPrefix(value, "{entry.")
+	if !ok || !strings.HasSuffix(inner, "}") {
+		return "", false
+	}
+
+	inner = strings.TrimSuffix(inner, "}")
+	if inner == "" || strings.ContainsAny(inner, "{}") {
+		return "", false
+	}
+	return inner, true
+}
@@ -0,0 +1,183 @@
+package mongo_log
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+const (
+	tenantDatabase         = "database"
+	tenantCollectionPrefix = "collection_prefix"
+
+	defaultTenantField = "request.host"
+
+	// Atlas caps database names at 38 bytes, less than the server's 64
+	maxTenantDatabaseName = 38
+
+	defaultMaxTenants = 100
+)
+
+// Tenant routes each entry to a database, or a collection prefix, named
+// after a field of the entry, isolating each tenant's logs. Field is a
+// dot-path such as request.host, a header like
+// request.headers.X-Tenant-Id, or a field added with log_append.
+type Tenant struct {
+	Field string `json:"field,omitempty"`
+	// database (default) or collection_prefix.
+	Mode string `json:"mode,omitempty"`
+	// Prepended to the tenant name, e.g. "logs_".
+	Prefix string `json:"prefix,omitempty"`
+	// Tenant name for entries without Field. Empty keeps the writer's
+	// database and collection.
+	Default string `json:"default,omitempty"`
+	// The tenants to route. Entries of other tenants keep the writer's
+	// database and collection.
+	Allow []string `json:"allow,omitempty"`
+	// Without Allow, routes the first MaxTenants tenants seen, 100 by
+	// default, and keeps later ones in the writer's database and
+	// collection, since Field is often client controlled.
+	MaxTenants int `json:"max_tenants,omitempty"`
+
+	allowed map[string]bool
+	mu      sync.Mutex
+	seen    map[string]bool
+}
+
+func parseTenant(d *caddyfile.Dispenser) (*Tenant, error) {
+	t := &Tenant{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		option := d.Val()
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+
+		switch option {
+		case "field":
+			t.Field = d.Val()
+		case "mode":
+			t.Mode = d.Val()
+		case "prefix":
+			t.Prefix = d.Val()
+		case "default":
+			t.Default = d.Val()
+		case "allow":
+			t.Allow = append(t.Allow, d.Val())
+			t.Allow = append(t.Allow, d.RemainingArgs()...)
+		case "max_tenants":
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid tenant max_tenants %q: %v", d.Val(), err)
+			}
+			t.MaxTenants = n
+		default:
+			return nil, d.Errf("unrecognized tenant option %q", option)
+		}
+	}
+
+	return t, nil
+}
+
+func (t *Tenant) provision() {
+	if t.Field == "" {
+		t.Field = defaultTenantField
+	}
+	if t.Mode == "" {
+		t.Mode = tenantDatabase
+	}
+	if t.MaxTenants <= 0 {
+		t.MaxTenants = defaultMaxTenants
+	}
+
+	t.allowed = map[string]bool{}
+	for _, tenant := range t.Allow {
+		t.allowed[tenantName(tenant)] = true
+	}
+	t.seen = map[string]bool{}
+}
+
+func (t *Tenant) validate() error {
+	switch t.Mode {
+	case tenantDatabase, tenantCollectionPrefix:
+	default:
+		return fmt.Errorf("invalid tenant mode %q, want %s or %s", t.Mode, tenantDatabase, tenantCollectionPrefix)
+	}
+
+	if t.Prefix != "" && tenantName(t.Prefix) != strings.ToLower(t.Prefix) {
+		return fmt.Errorf("tenant prefix %q may only hold letters, digits, - and _", t.Prefix)
+	}
+
+	return nil
+}
+
+// route moves database and collection to the entry's tenant.
+func (t *Tenant) route(entry map[string]interface{}, database, collection string) (string, string) {
+	tenant := t.Default
+	if v, ok := lookup(entry, splitPath(t.Field)...); ok {
+		// header values are lists
+		if values, ok := v.([]interface{}); ok && len(values) > 0 {
+			v = values[0]
+		}
+		if s, ok := v.(string); ok && s != "" {
+			tenant = s
+		}
+	}
+
+	if t.Field == defaultTenantField {
+		if host, _, err := net.SplitHostPort(tenant); err == nil {
+			tenant = host
+		}
+	}
+
+	name := tenantName(tenant)
+	if name == "" || !t.admit(name) {
+		return database, collection
+	}
+
+	if t.Mode == tenantCollectionPrefix {
+		return database, t.Prefix + name + "_" + collection
+	}
+
+	name = t.Prefix + name
+	return name[:min(len(name), maxTenantDatabaseName)], collection
+}
+
+// admit reports whether name gets its own database or collections: it
+// is allowed, or without Allow, one of the first MaxTenants seen.
+func (t *Tenant) admit(name string) bool {
+	if len(t.Allow) > 0 {
+		return t.allowed[name]
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.seen[name] {
+		return true
+	}
+	if len(t.seen) >= t.MaxTenants {
+		return false
+	}
+	t.seen[name] = true
+	return true
+}
+
+// tenantName lowercases s and replaces everything but letters, digits, -
+// and _ with _, so host names such as api.example.com become valid
+// database names.
+func tenantName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, s)
+}
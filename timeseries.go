@@ -0,0 +1,74 @@
+package mongo_log
+
+import (
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+const (
+	defaultTimeField = "date"
+	defaultMetaField = "tags"
+)
+
+// TimeSeries stores logs in a time-series collection, with the tags as
+// the meta field.
+type TimeSeries struct {
+	TimeField string `json:"time_field,omitempty"`
+	MetaField string `json:"meta_field,omitempty"`
+
+	// One of "seconds", "minutes" or "hours".
+	Granularity string `json:"granularity,omitempty"`
+}
+
+func parseTimeSeries(d *caddyfile.Dispenser) (*TimeSeries, error) {
+	ts := &TimeSeries{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "time_field":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			ts.TimeField = d.Val()
+
+		case "meta_field":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			ts.MetaField = d.Val()
+
+		case "granularity":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			ts.Granularity = d.Val()
+
+		default:
+			return nil, d.Errf("unrecognized timeseries option %q", d.Val())
+		}
+	}
+
+	return ts, nil
+}
+
+func (ts *TimeSeries) validate() error {
+	switch ts.Granularity {
+	case "", "seconds", "minutes", "hours":
+	default:
+		return fmt.Errorf("invalid timeseries granularity %q", ts.Granularity)
+	}
+
+	if ts.TimeField == ts.MetaField {
+		return fmt.Errorf("timeseries time_field and meta_field must differ")
+	}
+
+	if ts.TimeField == "metadata" || ts.MetaField == "metadata" {
+		return fmt.Errorf("timeseries fields cannot be named metadata")
+	}
+
+	return nil
+}
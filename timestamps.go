@@ -0,0 +1,79 @@
+package mongo_log
+
+import (
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// timeLayouts are the string forms Caddy's time_format option can produce.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.000Z0700",
+	"2006/01/02 15:04:05.000000000",
+	"2006/01/02 15:04:05",
+	"02/Jan/2006:15:04:05 -0700",
+}
+
+// parseTimestamp converts an encoded log timestamp into a time: a BSON
+// date, unix seconds, milliseconds or nanoseconds as a number, or one of
+// timeLayouts.
+func parseTimestamp(v interface{}) (time.Time, bool) {
+	switch ts := v.(type) {
+	case primitive.DateTime:
+		return ts.Time(), true
+
+	case int64:
+		return parseTimestamp(float64(ts))
+
+	case float64:
+		switch {
+		case ts <= 0:
+			return time.Time{}, false
+		case ts < 1e11:
+			sec, frac := math.Modf(ts)
+			return time.Unix(int64(sec), int64(frac*1e9)), true
+		case ts < 1e14:
+			return time.UnixMilli(int64(ts)), true
+		default:
+			return time.Unix(0, int64(ts)), true
+		}
+
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, ts); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// entryTime returns when the entry was logged, or now if it has no
+// parseable ts field.
+func entryTime(entry map[string]interface{}) time.Time {
+	if ts, ok := parseTimestamp(entry["ts"]); ok {
+		return ts
+	}
+
+	return time.Now()
+}
+
+// convertDates replaces the timestamps at the given dot-paths of a stored
+// entry with BSON dates.
+func convertDates(entry map[string]interface{}, fields []string) {
+	for _, field := range fields {
+		path := splitPath(field)
+
+		v, ok := lookup(entry, path...)
+		if !ok {
+			continue
+		}
+
+		if t, ok := parseTimestamp(v); ok {
+			setPath(entry, path, primitive.NewDateTimeFromTime(t))
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package mongo_log
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// TLSConfig configures TLS for the mongo connection beyond what the
+// connection string can express.
+type TLSConfig struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+func parseTLS(d *caddyfile.Dispenser) (*TLSConfig, error) {
+	t := &TLSConfig{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "ca_file":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			t.CAFile = d.Val()
+
+		case "cert_file":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			t.CertFile = d.Val()
+
+		case "key_file":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			t.KeyFile = d.Val()
+
+		case "server_name":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			t.ServerName = d.Val()
+
+		case "insecure_skip_verify":
+			t.InsecureSkipVerify = true
+			if d.NextArg() {
+				skip, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return nil, d.Errf("invalid insecure_skip_verify %q: %v", d.Val(), err)
+				}
+				t.InsecureSkipVerify = skip
+			}
+
+		default:
+			return nil, d.Errf("unrecognized tls option %q", d.Val())
+		}
+	}
+
+	return t, nil
+}
+
+// build loads the configured files into a tls.Config.
+func (t *TLSConfig) build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading tls ca_file: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in tls ca_file %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, fmt.Errorf("tls cert_file and key_file must be set together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading tls client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
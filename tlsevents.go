@@ -0,0 +1,133 @@
+package mongo_log
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const defaultTLSEventsCollection = "tls_events"
+
+var errNoCertificate = errors.New("no PEM certificate in storage")
+
+// tlsEventMessages maps the certificate lifecycle messages of Caddy's tls
+// loggers to the event stored for them.
+var tlsEventMessages = map[string]string{
+	"certificate obtained successfully":             "obtained",
+	"certificate renewed successfully":              "renewed",
+	"could not get certificate from issuer":         "failed",
+	"certificate expires soon; queuing for renewal": "expiring",
+	"advancing OCSP staple":                         "ocsp_updated",
+	"stapling OCSP":                                 "ocsp_failed",
+	"OCSP status for managed certificate is REVOKED; attempting to replace with new certificate": "revoked",
+	"on-demand certificate's OCSP status is REVOKED; will try to forcefully renew":               "revoked",
+}
+
+// recordTLSEvent stores a structured document in the TLSEvents collection
+// when entry is a certificate lifecycle message. Obtained and renewed
+// certificates are read from Caddy's storage for their names and
+// validity.
+func (mWrite *mongoWriter) recordTLSEvent(entry map[string]interface{}, now time.Time) {
+	logger := lookupString(entry, "logger")
+	if logger != "tls" && !strings.HasPrefix(logger, "tls.") {
+		return
+	}
+
+	event, ok := tlsEventMessages[lookupString(entry, "msg")]
+	if !ok {
+		return
+	}
+
+	doc := bson.M{
+		mWrite.config.dateField(): primitive.NewDateTimeFromTime(now),
+		"event":                   event,
+		"logger":                  logger,
+	}
+
+	var identifiers []string
+	if id := lookupString(entry, "identifier"); id != "" {
+		identifiers = append(identifiers, id)
+	}
+	if ids, ok := entry["identifiers"].([]interface{}); ok {
+		for _, id := range ids {
+			if s, ok := id.(string); ok {
+				identifiers = append(identifiers, s)
+			}
+		}
+	}
+	if len(identifiers) > 0 {
+		doc["identifiers"] = identifiers
+	}
+
+	issuer := lookupString(entry, "issuer")
+	if issuer != "" {
+		doc["issuer"] = issuer
+	}
+	if err := lookupString(entry, "error"); err != "" {
+		doc["error"] = err
+	}
+
+	// an expiring certificate is logged with its time left
+	if remaining, ok := toFloat(entry["remaining"]); ok {
+		doc["not_after"] = primitive.NewDateTimeFromTime(now.Add(time.Duration(remaining * float64(time.Second))))
+	}
+	if expiration, ok := parseTimestamp(entry["expiration"]); ok {
+		doc["not_after"] = primitive.NewDateTimeFromTime(expiration)
+	}
+	if next, ok := parseTimestamp(entry["to"]); ok && event == "ocsp_updated" {
+		doc["ocsp_next_update"] = primitive.NewDateTimeFromTime(next)
+	}
+
+	if (event == "obtained" || event == "renewed") && issuer != "" && len(identifiers) == 1 {
+		if cert, err := mWrite.loadCertificate(issuer, identifiers[0]); err == nil {
+			addCertificateFields(doc, cert)
+		} else {
+			mWrite.logger.Debug("Could not load certificate for TLS event", zap.String("identifier", identifiers[0]), zap.Error(err))
+		}
+	}
+
+	t := target{database: mWrite.config.Database, collection: mWrite.config.TLSEvents, auxiliary: true}
+	mWrite.add(t, doc)
+}
+
+// loadCertificate reads the leaf certificate certmagic stored for name.
+func (mWrite *mongoWriter) loadCertificate(issuer, name string) (*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	certPEM, err := mWrite.config.storage.Load(ctx, certmagic.StorageKeys.SiteCert(issuer, name))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errNoCertificate
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func addCertificateFields(doc bson.M, cert *x509.Certificate) {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	doc["sans"] = sans
+	doc["serial"] = cert.SerialNumber.Text(16)
+	doc["issuer_name"] = cert.Issuer.String()
+	doc["not_before"] = primitive.NewDateTimeFromTime(cert.NotBefore)
+	doc["not_after"] = primitive.NewDateTimeFromTime(cert.NotAfter)
+}
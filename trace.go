@@ -0,0 +1,74 @@
+package mongo_log
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	traceIdField      = "trace_id"
+	spanIdField       = "span_id"
+
+	// added to access logs by Caddy's tracing handler
+	caddyTraceIdField = "traceID"
+)
+
+// correlationFields are lifted from entries into top-level document fields
+// so logs can be joined with requests and traces.
+var correlationFields = []string{requestIdField, traceIdField, spanIdField}
+
+// parseTraceparent returns the trace id and flags of a W3C traceparent
+// header, or ok false when the header is malformed.
+func parseTraceparent(header string) (traceId, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", false
+	}
+
+	// version 00 has exactly four fields, later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", false
+	}
+
+	traceId, parentId, flags := parts[1], parts[2], parts[3]
+	if !isHex(parts[0]) || !isHex(traceId) || !isHex(parentId) || !isHex(flags) ||
+		len(traceId) != 32 || len(parentId) != 16 || len(flags) != 2 ||
+		allZero(traceId) || allZero(parentId) {
+		return "", "", false
+	}
+
+	return traceId, flags, true
+}
+
+// newTraceparent continues the trace of an incoming traceparent header with
+// a new span, or starts a new sampled trace.
+func newTraceparent(incoming string) (header, traceId, spanId string) {
+	traceId, flags, ok := parseTraceparent(incoming)
+	if !ok {
+		traceId, flags = randomHex(16), "01"
+	}
+
+	spanId = randomHex(8)
+	return "00-" + traceId + "-" + spanId + "-" + flags, traceId, spanId
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func allZero(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
@@ -0,0 +1,57 @@
+package mongo_log
+
+// transform applies the configured field rules to an entry before it is
+// stored.
+func (l *MongoLog) transform(entry map[string]interface{}) map[string]interface{} {
+	// before ECS and renames move the fields
+	normalizeIntegers(entry)
+
+	if l.Redact != nil {
+		l.Redact.apply(entry)
+	}
+
+	if l.AnonymizeIP != "" {
+		l.anonymizeIPs(entry)
+	}
+
+	if len(l.IncludeFields) > 0 {
+		entry = includeFields(entry, l.IncludeFields)
+	}
+
+	for _, field := range l.ExcludeFields {
+		deletePath(entry, splitPath(field))
+	}
+
+	if l.ECS {
+		entry = toECS(entry)
+	}
+
+	for from, to := range l.Rename {
+		if v, ok := deletePath(entry, splitPath(from)); ok {
+			setPath(entry, splitPath(to), v)
+		}
+	}
+
+	if len(l.Coerce) > 0 {
+		coerceFields(entry, l.Coerce)
+	}
+
+	if len(l.DateFields) > 0 {
+		convertDates(entry, l.DateFields)
+	}
+
+	return entry
+}
+
+// includeFields returns a copy of entry holding only the given dot-paths.
+func includeFields(entry map[string]interface{}, fields []string) map[string]interface{} {
+	kept := map[string]interface{}{}
+	for _, field := range fields {
+		path := splitPath(field)
+		if v, ok := lookup(entry, path...); ok {
+			setPath(kept, path, v)
+		}
+	}
+
+	return kept
+}
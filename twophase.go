@@ -0,0 +1,94 @@
+package mongo_log
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// inProgressField marks the document of a request that has not completed.
+const inProgressField = "in_progress"
+
+// requestStarted queues an in-progress entry for a request that just
+// started. Its document is upserted by request ID and completed by the
+// access log entry. The entry goes through the queue like any other, so it
+// is redacted and anonymized as usual and never blocks the request on
+// mongo.
+func (mWrite *mongoWriter) requestStarted(id string, r *http.Request) {
+	remoteIP, remotePort, _ := net.SplitHostPort(r.RemoteAddr)
+
+	entry, err := json.Marshal(map[string]interface{}{
+		"level":  "info",
+		"ts":     float64(time.Now().UnixNano()) / float64(time.Second),
+		"logger": "http.handlers.mongo_request_id",
+		"msg":    "request started",
+		"request": map[string]interface{}{
+			"remote_ip":   remoteIP,
+			"remote_port": remotePort,
+			"proto":       r.Proto,
+			"method":      r.Method,
+			"host":        r.Host,
+			"uri":         r.RequestURI,
+			"headers":     r.Header,
+		},
+		requestIdField:  id,
+		inProgressField: true,
+	})
+	if err != nil {
+		return
+	}
+
+	mWrite.enqueue(entry)
+}
+
+// writeDocs inserts docs into collection without ordering, or with
+// two_phase upserts those carrying a request ID: the in-progress document
+// only if nothing was stored yet, the completed one over it.
+func (mWrite *mongoWriter) writeDocs(ctx context.Context, collection *mongo.Collection, docs []interface{}) error {
+	if !mWrite.config.TwoPhase {
+		_, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		return err
+	}
+
+	models := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		models = append(models, twoPhaseModel(doc))
+	}
+
+	_, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func twoPhaseModel(doc interface{}) mongo.WriteModel {
+	var id string
+	var started bool
+
+	switch d := doc.(type) {
+	case bson.M:
+		id, _ = d[requestIdField].(string)
+		started, _ = d[inProgressField].(bool)
+	case bson.Raw:
+		id, _ = d.Lookup(requestIdField).StringValueOK()
+		started, _ = d.Lookup(inProgressField).BooleanOK()
+	}
+
+	if id == "" {
+		return mongo.NewInsertOneModel().SetDocument(doc)
+	}
+
+	update := bson.M{"$set": doc, "$unset": bson.M{inProgressField: ""}}
+	if started {
+		update = bson.M{"$setOnInsert": doc}
+	}
+
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{requestIdField: id}).
+		SetUpdate(update).
+		SetUpsert(true)
+}
@@ -0,0 +1,69 @@
+package mongo_log
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestTwoPhaseModel(t *testing.T) {
+	mustRaw := func(doc bson.M) bson.Raw {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	started := bson.M{requestIdField: "abc", inProgressField: true}
+	completed := bson.M{requestIdField: "abc", "status": 200}
+	// marshalled once, as map order would vary between encodings
+	rawStarted, rawCompleted := mustRaw(started), mustRaw(completed)
+
+	tests := []struct {
+		name       string
+		doc        interface{}
+		wantInsert bool
+		wantUpdate bson.M
+	}{
+		{name: "no request id", doc: bson.M{"status": 200}, wantInsert: true},
+		{name: "empty request id", doc: bson.M{requestIdField: "", "status": 200}, wantInsert: true},
+		{name: "started", doc: started, wantUpdate: bson.M{"$setOnInsert": started}},
+		{name: "completed", doc: completed, wantUpdate: bson.M{"$set": completed, "$unset": bson.M{inProgressField: ""}}},
+		{name: "encoded started", doc: rawStarted, wantUpdate: bson.M{"$setOnInsert": rawStarted}},
+		{name: "encoded completed", doc: rawCompleted, wantUpdate: bson.M{"$set": rawCompleted, "$unset": bson.M{inProgressField: ""}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := twoPhaseModel(tt.doc)
+
+			if tt.wantInsert {
+				insert, ok := model.(*mongo.InsertOneModel)
+				if !ok {
+					t.Fatalf("model = %T, want *mongo.InsertOneModel", model)
+				}
+				if !reflect.DeepEqual(insert.Document, tt.doc) {
+					t.Errorf("inserted %v, want %v", insert.Document, tt.doc)
+				}
+				return
+			}
+
+			update, ok := model.(*mongo.UpdateOneModel)
+			if !ok {
+				t.Fatalf("model = %T, want *mongo.UpdateOneModel", model)
+			}
+			if update.Upsert == nil || !*update.Upsert {
+				t.Error("update is not an upsert")
+			}
+			if want := (bson.M{requestIdField: "abc"}); !reflect.DeepEqual(update.Filter, want) {
+				t.Errorf("filter = %v, want %v", update.Filter, want)
+			}
+			if !reflect.DeepEqual(update.Update, tt.wantUpdate) {
+				t.Errorf("update = %v, want %v", update.Update, tt.wantUpdate)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package mongo_log
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	coerceInt          = "int"
+	coerceDouble       = "double"
+	coerceBool         = "bool"
+	coerceString       = "string"
+	coerceMilliseconds = "milliseconds"
+)
+
+// maxExactInt is the largest integer a float64 holds without rounding.
+const maxExactInt = 1 << 53
+
+func validateCoerce(coerce map[string]string) error {
+	for field, kind := range coerce {
+		switch kind {
+		case coerceInt, coerceDouble, coerceBool, coerceString, coerceMilliseconds:
+		default:
+			return fmt.Errorf("invalid coerce type %q for %s", kind, field)
+		}
+	}
+	return nil
+}
+
+// integerFields are the entry fields Caddy always logs as integers. Only
+// these are stored as int64, so fields such as duration stay doubles even
+// when whole; coerce fixes the type of any other field.
+var integerFields = [][]string{
+	{"status"},
+	{"size"},
+	{"bytes_read"},
+	{"request", "tls", "version"},
+	{"request", "tls", "cipher_suite"},
+}
+
+// normalizeIntegers stores the integerFields of an entry as int64 rather
+// than the double encoding/json decodes every number to.
+func normalizeIntegers(entry map[string]interface{}) {
+	for _, path := range integerFields {
+		v, ok := lookup(entry, path...)
+		if !ok {
+			continue
+		}
+
+		if f, ok := v.(float64); ok && f == math.Trunc(f) && math.Abs(f) <= maxExactInt {
+			setPath(entry, path, int64(f))
+		}
+	}
+}
+
+// normalizeNumbers stores whole JSON numbers as int64 rather than the
+// double encoding/json decodes every number to, recursively. It is meant
+// for free-form data such as event payloads, not log entries, whose types
+// must not depend on their values.
+func normalizeNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalizeNumbers(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = normalizeNumbers(value)
+		}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) <= maxExactInt {
+			return int64(v)
+		}
+	}
+	return v
+}
+
+// coerceFields converts the given dot-paths of a stored entry to a fixed
+// type. Values that cannot be converted are left as they are.
+func coerceFields(entry map[string]interface{}, coerce map[string]string) {
+	for field, kind := range coerce {
+		path := splitPath(field)
+
+		v, ok := lookup(entry, path...)
+		if !ok {
+			continue
+		}
+
+		if converted, ok := coerceValue(v, kind); ok {
+			setPath(entry, path, converted)
+		}
+	}
+}
+
+func coerceValue(v interface{}, kind string) (interface{}, bool) {
+	switch kind {
+	case coerceInt:
+		switch v := v.(type) {
+		case int64:
+			return v, true
+		case int32:
+			return int64(v), true
+		case float64:
+			return int64(v), true
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			return n, err == nil
+		}
+
+	case coerceDouble:
+		if f, ok := toFloat(v); ok {
+			return f, true
+		}
+
+	case coerceBool:
+		switch v := v.(type) {
+		case bool:
+			return v, true
+		case string:
+			b, err := strconv.ParseBool(v)
+			return b, err == nil
+		}
+
+	case coerceString:
+		return fmt.Sprint(v), true
+
+	case coerceMilliseconds:
+		// Caddy logs durations as seconds; also accept "1.5s" strings
+		if s, ok := v.(string); ok {
+			d, err := time.ParseDuration(s)
+			return float64(d) / float64(time.Millisecond), err == nil
+		}
+		if seconds, ok := toFloat(v); ok {
+			return seconds * 1000, true
+		}
+	}
+
+	return nil, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
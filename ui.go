@@ -0,0 +1,113 @@
+package mongo_log
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+//go:embed ui/index.html
+var uiPage []byte
+
+func init() {
+	caddy.RegisterModule(MongoLogUI{})
+	httpcaddyfile.RegisterHandlerDirective("mongo_log_ui", parseUICaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("mongo_log_ui", httpcaddyfile.Before, "abort")
+}
+
+// MongoLogUI serves a small page to search and inspect stored log
+// documents by time range, status, host, path and request ID. Its queries
+// go to api/query below the page, which takes the same parameters as the
+// admin API's /mongo_log/query except collection: it only searches the
+// writer's current collection. Protect it like any other internal tool,
+// e.g. with basic_auth.
+type MongoLogUI struct {
+	// The writer to search, named by database.collection. May be empty
+	// when only one writer is open.
+	Writer string `json:"writer,omitempty"`
+}
+
+// CaddyModule implements caddy.Module.
+func (MongoLogUI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.mongo_log_ui",
+		New: func() caddy.Module { return new(MongoLogUI) },
+	}
+}
+
+func (m MongoLogUI) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return caddyhttp.Error(http.StatusMethodNotAllowed, nil)
+	}
+
+	if !strings.HasSuffix(r.URL.Path, "/api/query") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		_, err := w.Write(uiPage)
+		return err
+	}
+
+	// only the admin API may pick another collection of the database
+	query := r.URL.Query()
+	query.Set("writer", m.Writer)
+	query.Del("collection")
+
+	docs, err := queryLogs(r.Context(), query)
+	if err != nil {
+		var apiErr caddy.APIError
+		if errors.As(err, &apiErr) {
+			return caddyhttp.Error(apiErr.HTTPStatus, apiErr.Err)
+		}
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(docs)
+}
+
+func (m *MongoLogUI) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			m.Writer = d.Val()
+		}
+
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "writer":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+
+				m.Writer = d.Val()
+
+			default:
+				return d.Errf("unrecognized mongo_log_ui option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseUICaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	m := new(MongoLogUI)
+	err := m.UnmarshalCaddyfile(h.Dispenser)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Interface guards.
+var (
+	_ caddyhttp.MiddlewareHandler = (*MongoLogUI)(nil)
+	_ caddyfile.Unmarshaler       = (*MongoLogUI)(nil)
+)
@@ -0,0 +1,56 @@
+package mongo_log
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// validateURI parses a connection string. Parsing a mongodb+srv URI looks
+// up its SRV and TXT records, so unless resolve is set those are checked
+// for syntax only.
+func validateURI(uri string, resolve bool) error {
+	if resolve || !strings.HasPrefix(uri, connstring.SchemeMongoDBSRV+"://") {
+		_, err := connstring.ParseAndValidate(uri)
+		return err
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+
+	if u.Port() != "" || strings.Contains(u.Host, ",") {
+		return fmt.Errorf("a %s URI must have a single host and no port", connstring.SchemeMongoDBSRV)
+	}
+
+	// srv-only options are rejected by the plain scheme
+	query := u.Query()
+	query.Del("srvServiceName")
+	query.Del("srvMaxHosts")
+
+	u.Scheme = connstring.SchemeMongoDB
+	u.RawQuery = query.Encode()
+
+	_, err = connstring.ParseAndValidate(u.String())
+	return err
+}
+
+// uriHosts returns the host list of a connection string, without its
+// scheme, credentials, database or options.
+func uriHosts(uri string) string {
+	_, hosts, ok := strings.Cut(uri, "://")
+	if !ok {
+		hosts = uri
+	}
+
+	if i := strings.IndexAny(hosts, "/?"); i >= 0 {
+		hosts = hosts[:i]
+	}
+	if i := strings.LastIndexByte(hosts, '@'); i >= 0 {
+		hosts = hosts[i+1:]
+	}
+	return hosts
+}
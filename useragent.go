@@ -0,0 +1,44 @@
+package mongo_log
+
+import (
+	"strings"
+
+	"github.com/mssola/useragent"
+)
+
+// parseUserAgent adds the browser, operating system and device class of
+// the request's User-Agent header to an entry under "user_agent".
+func parseUserAgent(entry map[string]interface{}) {
+	header := headerValue(entry, "request", "headers", "User-Agent")
+	if header == "" {
+		return
+	}
+
+	ua := useragent.New(header)
+	browser, version := ua.Browser()
+	os := ua.OSInfo()
+
+	entry["user_agent"] = map[string]interface{}{
+		"browser":    browser,
+		"version":    version,
+		"os":         os.Name,
+		"os_version": os.Version,
+		"device":     deviceClass(ua, header),
+		"bot":        ua.Bot(),
+	}
+}
+
+// deviceClass returns bot, tablet, mobile or desktop.
+func deviceClass(ua *useragent.UserAgent, header string) string {
+	switch {
+	case ua.Bot():
+		return "bot"
+	case strings.Contains(header, "iPad") || strings.Contains(header, "Tablet") ||
+		strings.Contains(header, "Android") && !strings.Contains(header, "Mobile"):
+		return "tablet"
+	case ua.Mobile():
+		return "mobile"
+	default:
+		return "desktop"
+	}
+}
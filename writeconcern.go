@@ -0,0 +1,94 @@
+package mongo_log
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// WriteConcern configures the acknowledgment requested for log inserts.
+type WriteConcern struct {
+	// Number of nodes ("0", "1", ...) or a tag such as "majority".
+	W        string         `json:"w,omitempty"`
+	Journal  *bool          `json:"journal,omitempty"`
+	WTimeout caddy.Duration `json:"wtimeout,omitempty"`
+}
+
+func parseWriteConcern(d *caddyfile.Dispenser) (*WriteConcern, error) {
+	wc := &WriteConcern{}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "w":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			wc.W = d.Val()
+
+		case "journal":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			journal, err := strconv.ParseBool(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid journal %q: %v", d.Val(), err)
+			}
+			wc.Journal = &journal
+
+		case "wtimeout":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			timeout, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid wtimeout %q: %v", d.Val(), err)
+			}
+			wc.WTimeout = caddy.Duration(timeout)
+
+		default:
+			return nil, d.Errf("unrecognized write_concern option %q", d.Val())
+		}
+	}
+
+	return wc, nil
+}
+
+func (wc *WriteConcern) validate() error {
+	if n, err := strconv.Atoi(wc.W); err == nil {
+		if n < 0 {
+			return fmt.Errorf("write_concern w cannot be negative")
+		}
+
+		if n == 0 && wc.Journal != nil && *wc.Journal {
+			return fmt.Errorf("write_concern w 0 cannot be journaled")
+		}
+	}
+
+	if wc.WTimeout < 0 {
+		return fmt.Errorf("write_concern wtimeout cannot be negative")
+	}
+
+	return nil
+}
+
+func (wc *WriteConcern) writeConcern() *writeconcern.WriteConcern {
+	concern := &writeconcern.WriteConcern{
+		Journal:  wc.Journal,
+		WTimeout: time.Duration(wc.WTimeout),
+	}
+
+	if n, err := strconv.Atoi(wc.W); err == nil {
+		concern.W = n
+	} else if wc.W != "" {
+		concern.W = wc.W
+	}
+
+	return concern
+}